@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"../ffnn"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+
+type LayerSpec struct {
+	OutputSize int
+	// Empty for the default activator
+	Activator string
+}
+
+
+// Parses a comma-separated list of layers, each one being
+//   `size` or `size:Activator` (e.g. "200:Sigmoid,10"). The
+//   last layer is the output layer.
+func ParseArchitecture(architecture string) ([]LayerSpec, error) {
+	parts := strings.Split(architecture, ",")
+	layers := make([]LayerSpec, 0, len(parts))
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			return nil, errors.New(fmt.Sprintf("empty layer in architecture %q", architecture))
+		}
+		fields := strings.SplitN(part, ":", 2)
+		size, err := strconv.Atoi(fields[0])
+		if err != nil || size < 1 {
+			return nil, errors.New(fmt.Sprintf("invalid layer size %q in architecture %q", fields[0], architecture))
+		}
+		layer := LayerSpec{OutputSize: size}
+		if len(fields) == 2 {
+			layer.Activator = fields[1]
+		}
+		layers = append(layers, layer)
+	}
+	return layers, nil
+}
+
+
+func checkOutputSize(architecture string, outputSize int) error {
+	if layers, err := ParseArchitecture(architecture); err != nil {
+		return err
+	} else if size := layers[len(layers) - 1].OutputSize; size != outputSize {
+		return errors.New(fmt.Sprintf("the output layer must have size %v, not %v", outputSize, size))
+	}
+	return nil
+}
+
+
+func NewNetwork(inputSize int, learningRate float64, architecture string, preprocessing *ffnn.Preprocessing) (*ffnn.FFNetwork, error) {
+	if learningRate <= 0 {
+		return nil, errors.New("learning rate must be positive (and, preferably, small)")
+	}
+	layers, err := ParseArchitecture(architecture)
+	if err != nil {
+		return nil, err
+	}
+
+	builder := ffnn.New(learningRate, inputSize, ffnn.HalfSquaredError{})
+	builder.WithPreprocessing(preprocessing)
+	for _, layer := range layers {
+		activator := ffnn.GetActivator("")
+		if layer.Activator != "" {
+			var err error
+			if activator, err = ffnn.LookupActivator(layer.Activator); err != nil {
+				return nil, err
+			}
+		}
+		builder.AddLayer(layer.OutputSize, activator)
+	}
+	return builder.Build(), nil
+}
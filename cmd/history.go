@@ -0,0 +1,222 @@
+package cmd
+
+import (
+	"../ffnn"
+	"../metrics"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"gonum.org/v1/gonum/mat"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+
+// Running sums of the training steps, since the last record
+type historyWindow struct {
+	steps        int
+	loss         float64
+	correct      int
+	gradientNorm float64
+}
+
+func (window *historyWindow) add(loss float64, correct bool, gradientNorm float64) {
+	window.steps++
+	window.loss += loss
+	window.gradientNorm += gradientNorm
+	if correct {
+		window.correct++
+	}
+}
+
+// The averages as a record, resetting the window
+func (window *historyWindow) record(kind string, state *ffnn.Checkpoint, learningRate float64, wallTime float64) *metrics.HistoryRecord {
+	record := &metrics.HistoryRecord{
+		Kind: kind, Epoch: state.Epoch, Step: state.Network.Step(), LearningRate: learningRate, WallTime: wallTime,
+	}
+	if window.steps > 0 {
+		record.Loss = window.loss / float64(window.steps)
+		record.Accuracy = float64(window.correct) / float64(window.steps)
+		record.GradientNorm = window.gradientNorm / float64(window.steps)
+	}
+	*window = historyWindow{}
+	return record
+}
+
+
+// The bookkeeping of a training session: the history records, and
+//   the metadata of the network
+type trainingSession struct {
+	options         TrainingOptions
+	started         time.Time
+	datasets        map[string]string
+	hyperparameters map[string]string
+	steps           historyWindow
+	epoch           historyWindow
+	activations     activationSamples
+	// The predictions of the current epoch, for the dashboard
+	classification *metrics.Classification
+	// Only with the options' Metrics
+	metrics *trainingMetrics
+	// When (and at which step) the metadata was last updated
+	updated     time.Time
+	updatedStep int
+}
+
+func newTrainingSession(state *ffnn.Checkpoint, options TrainingOptions) (*trainingSession, error) {
+	now := time.Now()
+	session := &trainingSession{
+		options:         options,
+		started:         now,
+		datasets:        map[string]string{},
+		hyperparameters: map[string]string{},
+		updated:         now,
+		updatedStep:     state.Network.Step(),
+	}
+	if options.Metrics != nil {
+		session.metrics = newTrainingMetrics(options.Metrics, state)
+	}
+	for role, filename := range map[string]string{"train": options.Filename, "validation": options.ValidationFilename} {
+		if filename == "" {
+			continue
+		}
+		if fingerprint, err := Fingerprint(filename); err != nil {
+			return nil, err
+		} else {
+			session.datasets[role] = fingerprint
+		}
+	}
+
+	optimizer := state.Network.Optimizer()
+	params, _ := json.Marshal(optimizer)
+	session.hyperparameters["optimizer"] = optimizer.Name() + " " + string(params)
+	session.hyperparameters["learning_rate"] = strconv.FormatFloat(state.LearningRate, 'g', -1, 64)
+	session.hyperparameters["epochs"] = strconv.Itoa(options.Epochs)
+	if state.Schedule != nil {
+		params, _ := json.Marshal(state.Schedule)
+		session.hyperparameters["schedule"] = state.Schedule.Name() + " " + string(params)
+	}
+	if l1, l2 := state.Network.Regularization(); l1 != 0 || l2 != 0 {
+		session.hyperparameters["regularization"] = fmt.Sprintf("L1 = %v, L2 = %v", l1, l2)
+	}
+	if options.Augmentation != nil {
+		session.hyperparameters["augmentation"] = fmt.Sprintf("%T", options.Augmentation)
+	}
+	for name, value := range options.Hyperparameters {
+		session.hyperparameters[name] = value
+	}
+	return session, nil
+}
+
+// Accounts a training step, writing a step record when due
+func (session *trainingSession) step(state *ffnn.Checkpoint, learningRate float64, loss float64, output mat.Matrix, label int) error {
+	correct := metrics.Argmax(output) == label
+	if session.options.Dashboard != nil {
+		if session.classification == nil {
+			session.classification = metrics.NewClassification(10, 1)
+		}
+		if err := session.classification.Add(output, label); err != nil {
+			return err
+		}
+	}
+	gradientNorm := state.Network.GradientNorm()
+	session.steps.add(loss, correct, gradientNorm)
+	session.epoch.add(loss, correct, gradientNorm)
+	if session.options.TensorBoard != nil && state.Network.Step() % activationsEvery == 0 {
+		session.activations.add(state.Network)
+	}
+	if session.options.LogEvery < 1 || session.steps.steps < session.options.LogEvery {
+		return nil
+	}
+	return session.write(
+		state, session.steps.record(metrics.StepRecord, state, learningRate, time.Since(session.started).Seconds()),
+	)
+}
+
+// Writes the record to the history, TensorBoard, the dashboard and
+//   the metrics, if any
+func (session *trainingSession) write(state *ffnn.Checkpoint, record *metrics.HistoryRecord) error {
+	if session.metrics != nil {
+		session.metrics.record(record)
+	}
+	if dashboard := session.options.Dashboard; dashboard != nil {
+		dashboard.Record(record)
+		dashboard.Layers(state.Network)
+		if session.classification != nil {
+			dashboard.Confusion(fmt.Sprintf("training, epoch %v", record.Epoch), session.classification)
+		}
+	}
+	if session.options.History != nil {
+		if err := session.options.History.Write(record); err != nil {
+			return err
+		}
+	}
+	if session.options.TensorBoard != nil {
+		return writeScalars(session.options.TensorBoard, record)
+	}
+	return nil
+}
+
+// Writes the epoch record, and updates the metadata with it. The
+//   epoch is the completed one.
+func (session *trainingSession) endEpoch(state *ffnn.Checkpoint, learningRate float64, validation *float64) error {
+	record := session.epoch.record(metrics.EpochRecord, state, learningRate, time.Since(session.started).Seconds())
+	record.Epoch = state.Epoch - 1
+	record.ValidationAccuracy = validation
+
+	metadata := session.updateMetadata(state)
+	metadata.Epochs++
+	metadata.Metrics = map[string]float64{"loss": record.Loss, "accuracy": record.Accuracy}
+	if validation != nil {
+		metadata.Metrics["validation_accuracy"] = *validation
+	}
+	fmt.Printf("Loss: %v, accuracy: %v\n", record.Loss, record.Accuracy)
+
+	err := session.write(state, record)
+	// The next epoch starts a new confusion matrix
+	session.classification = nil
+	if err != nil {
+		return err
+	}
+	if session.options.TensorBoard != nil {
+		return session.activations.writeHistograms(session.options.TensorBoard, state.Network)
+	}
+	return nil
+}
+
+// Accounts the steps and time since the last update into the
+//   metadata (creating it if needed)
+func (session *trainingSession) updateMetadata(state *ffnn.Checkpoint) *ffnn.Metadata {
+	network := state.Network
+	metadata := network.Metadata()
+	if metadata == nil {
+		metadata = &ffnn.Metadata{}
+		network.SetMetadata(metadata)
+	}
+	now := time.Now()
+	metadata.Steps += network.Step() - session.updatedStep
+	metadata.TrainingSeconds += now.Sub(session.updated).Seconds()
+	metadata.Datasets = session.datasets
+	metadata.Hyperparameters = session.hyperparameters
+	metadata.Updated = now.UTC()
+	session.updated, session.updatedStep = now, network.Step()
+	return metadata
+}
+
+
+// The SHA-256 of the file content, as "sha256:<hex>"
+func Fingerprint(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
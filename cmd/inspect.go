@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"../ffnn"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+
+// Writes the structure of the network, its parameter statistics
+//   and its provenance, as text or JSON
+func Inspect(network *ffnn.FFNetwork, output io.Writer, asJSON bool) error {
+	summary := network.Summary()
+	if asJSON {
+		encoder := json.NewEncoder(output)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(summary)
+	}
+
+	builder := &strings.Builder{}
+	fmt.Fprintf(builder, "Input size: %v\n", summary.InputSize)
+	if summary.Preprocessing != nil {
+		fmt.Fprintf(builder, "Preprocessing: %v\n", strings.Join(summary.Preprocessing, " "))
+	}
+	for index, layer := range summary.Layers {
+		fmt.Fprintf(
+			builder, "Layer %v: %v -> %v (%v), %v parameters\n",
+			index, layer.InputSize, layer.OutputSize, layer.Activator, layer.Parameters,
+		)
+		writeStats(builder, "weights", layer.Weights)
+		writeStats(builder, "biases", layer.Biases)
+	}
+	if summary.TargetScaling != nil {
+		fmt.Fprintf(builder, "Target scaling: %v\n", strings.Join(summary.TargetScaling, " "))
+	}
+	fmt.Fprintf(builder, "Parameters: %v\n", summary.Parameters)
+	fmt.Fprintf(builder, "Error metric: %v\n", summary.Loss)
+	fmt.Fprintf(builder, "Optimizer: %v\n", summary.Optimizer)
+	if summary.L1 != 0 || summary.L2 != 0 {
+		fmt.Fprintf(builder, "Regularization: L1 = %v, L2 = %v\n", summary.L1, summary.L2)
+	}
+	fmt.Fprintf(builder, "Default learning rate: %v\n", summary.DefaultLearningRate)
+	if metadata := summary.Metadata; metadata != nil {
+		fmt.Fprintf(
+			builder, "Trained: %v epochs, %v steps, %.1fs (last on %v)\n",
+			metadata.Epochs, metadata.Steps, metadata.TrainingSeconds, metadata.Updated.Format(time.RFC3339),
+		)
+		for _, name := range sortedKeys(metadata.Metrics) {
+			fmt.Fprintf(builder, "  %v: %v\n", name, metadata.Metrics[name])
+		}
+		for _, role := range sortedStringKeys(metadata.Datasets) {
+			fmt.Fprintf(builder, "  %v dataset: %v\n", role, metadata.Datasets[role])
+		}
+		for _, name := range sortedStringKeys(metadata.Hyperparameters) {
+			fmt.Fprintf(builder, "  %v = %v\n", name, metadata.Hyperparameters[name])
+		}
+	}
+	if summary.Checksum != "" {
+		fmt.Fprintf(builder, "Checksum: %v\n", summary.Checksum)
+	}
+
+	_, err := io.WriteString(output, builder.String())
+	return err
+}
+
+func writeStats(builder *strings.Builder, name string, stats ffnn.Stats) {
+	fmt.Fprintf(
+		builder, "  %-8s mean %.4g, std %.4g, min %.4g, max %.4g, sparsity %.2f%%, L1 %.4g, L2 %.4g\n",
+		name + ":", stats.Mean, stats.Std, stats.Min, stats.Max, stats.Sparsity * 100, stats.L1Norm, stats.L2Norm,
+	)
+}
+
+
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedStringKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
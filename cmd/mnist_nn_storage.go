@@ -1,23 +1,135 @@
 package cmd
 
 
-import "../ffnn"
+import (
+	"../ffnn"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
 
 
 const Filename = "./network"
+const DefaultArchitecture = "200,10"
+const DefaultLearningRate = 0.01
 
 
+// Maps the raw [0, 255] pixels into [0.01, 1.0]
+func NewMNISTPreprocessing() *ffnn.Preprocessing {
+	return ffnn.NewPreprocessing(784, ffnn.NewFixedMinMaxScaler(0, 255, 0.01, 1.0))
+}
+
 func NewMNISTNetwork() *ffnn.FFNetwork {
-	networkBuilder := ffnn.New(0.01, 784, ffnn.HalfSquaredError{})
-	networkBuilder.AddLayer(200, ffnn.Sigmoid{})
-	networkBuilder.AddLayer(10, ffnn.Sigmoid{})
-	return networkBuilder.Build()
+	network, _ := NewMNISTNetworkWith(DefaultArchitecture, DefaultLearningRate)
+	return network
+}
+
+// Creates a network for MNIST using an architecture spec (see
+//   ParseArchitecture), which must end in a 10-sized layer.
+func NewMNISTNetworkWith(architecture string, learningRate float64) (*ffnn.FFNetwork, error) {
+	if err := checkOutputSize(architecture, 10); err != nil {
+		return nil, err
+	}
+	return NewNetwork(784, learningRate, architecture, NewMNISTPreprocessing())
+}
+
+// Creates a network for MNIST from a config file (see
+//   ffnn.Config), which must have 784 inputs and 10 outputs.
+func NewMNISTNetworkFromConfig(filename string) (*ffnn.FFNetwork, error) {
+	config, err := ffnn.LoadConfig(filename)
+	if err != nil {
+		return nil, err
+	}
+	if config.InputSize != 784 {
+		return nil, errors.New(fmt.Sprintf("%v: input_size must be 784 for MNIST, not %v", filename, config.InputSize))
+	}
+	if size := config.Layers[len(config.Layers) - 1].Size; size != 10 {
+		return nil, errors.New(fmt.Sprintf("%v: the output layer must have size 10 for MNIST, not %v", filename, size))
+	}
+	builder, err := ffnn.NewFromConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return builder.WithPreprocessing(NewMNISTPreprocessing()).Build(), nil
 }
 
 func SaveMNISTNetwork(network *ffnn.FFNetwork) error {
-	return ffnn.Save(network, Filename)
+	return SaveNetwork(network, Filename)
 }
 
 func LoadMNISTNetwork() (*ffnn.FFNetwork, error) {
-	return ffnn.Load(Filename)
+	return LoadNetwork(Filename)
+}
+
+func SaveNetwork(network *ffnn.FFNetwork, filename string) error {
+	return ffnn.Save(network, filename)
+}
+
+func SaveNetworkWith(network *ffnn.FFNetwork, filename string, options ffnn.SaveOptions) error {
+	return ffnn.SaveWith(network, filename, options)
+}
+
+// Reads a signing key, which is the content of the file with
+//   any surrounding whitespace (e.g. a trailing newline) removed
+func ReadKey(filename string) ([]byte, error) {
+	if filename == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	key := []byte(strings.TrimSpace(string(data)))
+	if len(key) == 0 {
+		return nil, errors.New(fmt.Sprintf("the key file %v is empty", filename))
+	}
+	return key, nil
+}
+
+// Parses the save options as given in the command line
+func ParseSaveOptions(format string, float32 bool, gzip bool) (ffnn.SaveOptions, error) {
+	options := ffnn.SaveOptions{}
+	switch format {
+	case "json":
+		options.Format = ffnn.JSONFormat
+	case "binary":
+		options.Format = ffnn.BinaryFormat
+	default:
+		return options, errors.New(fmt.Sprintf("unknown format %q (known: json, binary)", format))
+	}
+	if float32 {
+		options.Precision = ffnn.SinglePrecision
+	}
+	if gzip {
+		options.Compression = ffnn.GzipCompression
+	}
+	return options, nil
+}
+
+func LoadNetwork(filename string) (*ffnn.FFNetwork, error) {
+	return LoadNetworkWith(filename, ffnn.LoadOptions{})
+}
+
+func LoadNetworkWith(filename string, options ffnn.LoadOptions) (*ffnn.FFNetwork, error) {
+	network, err := ffnn.LoadWith(filename, options)
+	if err == nil && network.Preprocessing() == nil && network.InputSize() == 784 {
+		// MNIST networks saved before the preprocessing was stored
+		//   in the file expect the inputs already scaled
+		err = network.SetPreprocessing(NewMNISTPreprocessing())
+	}
+	return network, err
+}
+
+// Loads a checkpoint file or, given a directory, its latest
+//   checkpoint. Returns the file name too.
+func LoadCheckpoint(path string) (string, *ffnn.Checkpoint, error) {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		rotation := &ffnn.CheckpointRotation{Directory: path}
+		if path, err = rotation.Latest(); err != nil {
+			return "", nil, err
+		}
+	}
+	checkpoint, err := ffnn.LoadCheckpoint(path)
+	return path, checkpoint, err
 }
@@ -1,15 +1,25 @@
 package cmd
 
 import (
+	"context"
 	"../ffnn"
+	"../dashboard"
+	"../datasets"
+	"../metrics"
+	"../monitoring"
+	"../tensorboard"
+	"../utils/random"
 	"os"
 	"encoding/csv"
+	"encoding/json"
 	"bufio"
 	"strconv"
 	"gonum.org/v1/gonum/mat"
 	"time"
 	"fmt"
 	"io"
+	"math/rand"
+	"errors"
 )
 
 
@@ -17,13 +27,25 @@ const TrainingFile = "./mnist_train.csv"
 const TestingFile = "./mnist_test.csv"
 
 
-func makeInput(record []string) *mat.Dense {
-	inputs := make([]float64, 784)
-	for i := range inputs {
-		x, _ := strconv.ParseFloat(record[i + 1], 64)
-		inputs[i] = (x / 255.0 * 0.99) + 0.01
+// The raw 28x28 image, in the [0, 255] range
+func makeImage(record []string) *mat.Dense {
+	pixels := make([]float64, 784)
+	for i := range pixels {
+		pixels[i], _ = strconv.ParseFloat(record[i + 1], 64)
 	}
-	return mat.NewDense(784, 1, inputs)
+	return mat.NewDense(28, 28, pixels)
+}
+
+
+// Flattens the image into the 784 column. The values are kept
+//   raw, since the scaling is part of the network preprocessing
+func flatten(image *mat.Dense) *mat.Dense {
+	return mat.NewDense(784, 1, mat.DenseCopyOf(image).RawMatrix().Data)
+}
+
+
+func makeInput(record []string) *mat.Dense {
+	return flatten(makeImage(record))
 }
 
 
@@ -43,88 +65,367 @@ func makePair(record []string) (*mat.Dense, *mat.Dense) {
 }
 
 
-func TrainMNISTNetwork(network *ffnn.FFNetwork, epochs int) {
-	fmt.Printf("Starting the training with %v epocs\n", epochs)
-	t1 := time.Now()
-	for epoch := 0; epoch < epochs; epoch++ {
-		if trainFile, err := os.Open(TrainingFile); err == nil {
-			fmt.Println("Starting epoch:", epoch)
-			csvReader := csv.NewReader(bufio.NewReader(trainFile))
-			first := true
-			index := 0
-			for {
-				var record []string
-				var err error
-				if record, err = csvReader.Read(); err != nil {
-					break
-				}
-
-				if first {
-					first = false
-					continue
-				}
-
-				// train the NN with that data
-				inputs, targets := makePair(record)
-				network.Train(inputs, targets)
-				index++
-			}
-			trainFile.Close()
-			fmt.Println("Epoch ended.")
-		} else {
-			fmt.Printf("Epoch could not be started! : %v\n", err)
+// Iterates the records (label, 784 pixels) of a MNIST csv file,
+//   skipping its header, until the end or an error.
+func readMNIST(filename string, each func(record []string) error) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	csvReader := csv.NewReader(bufio.NewReader(file))
+	first := true
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if first {
+			first = false
+			continue
+		}
+
+		if len(record) != 785 {
+			return errors.New(fmt.Sprintf("%v: expected 785 columns, got %v", filename, len(record)))
+		}
+		if label, err := strconv.Atoi(record[0]); err != nil || label < 0 || label > 9 {
+			return errors.New(fmt.Sprintf("%v: invalid label %q", filename, record[0]))
+		}
+		if err := each(record); err != nil {
+			return err
 		}
 	}
-	elapsed := time.Since(t1)
-	fmt.Printf("Training used %v epoch and took: %v\n", epochs, elapsed)
 }
 
 
-func TestMNISTNetwork(network *ffnn.FFNetwork) {
+type TrainingOptions struct {
+	// The MNIST csv training file
+	Filename string
+	// The total epochs: a resumed training only does the
+	//   remaining ones
+	Epochs int
+	// When 0, the network's default learning rate is used
+	LearningRate float64
+	// Optional schedule of the learning rate, over the epochs
+	Schedule ffnn.Schedule
+	// Optional augmentation (and its generator), applied on-the-fly
+	//   to each image before flattening it
+	Augmentation datasets.Augmentation
+	Random *rand.Rand
+	// The source of Random, if its state must be saved in (and
+	//   restored from) the checkpoints
+	Source *random.Source
+	// Optional MNIST csv file to validate against after each
+	//   epoch, keeping track of the best accuracy
+	ValidationFilename string
+	// Where to save the network with the best validation accuracy
+	//   (optional)
+	BestFilename string
+	// Optional checkpoints, saved after each epoch and, when
+	//   CheckpointEvery > 0, every that many samples
+	Checkpoints *ffnn.CheckpointRotation
+	CheckpointEvery int
+	// Where to save a checkpoint when the training is interrupted,
+	//   if there are no Checkpoints (optional)
+	InterruptFilename string
+	// Optional history of the training: a record per epoch and, when
+	//   LogEvery > 0, a record every that many steps
+	History  metrics.HistoryWriter
+	LogEvery int
+	// Optional TensorBoard events: the scalars of the history
+	//   records, and histograms of the weights and activations of
+	//   each layer after each epoch
+	TensorBoard *tensorboard.Writer
+	// Optional live dashboard, fed with the history records, the
+	//   statistics of the layers and the confusion matrix of the
+	//   epoch in progress
+	Dashboard *dashboard.Dashboard
+	// Optional registry to expose the training gauges with (loss,
+	//   accuracy, learning rate, throughput, epochs...)
+	Metrics *monitoring.Registry
+	// Further settings to record in the network metadata (e.g. the
+	//   seed), besides the ones in these options
+	Hyperparameters map[string]string
+}
+
+
+// Returned (wrapped) when the training context is cancelled
+var ErrInterrupted = errors.New("training interrupted")
+
+
+func TrainMNIST(network *ffnn.FFNetwork, options TrainingOptions) error {
+	return TrainMNISTContext(context.Background(), network, options)
+}
+
+
+// Trains until done or until the context is cancelled. In the
+//   latter case, the training stops between two samples, saves a
+//   checkpoint (if configured to) and fails with ErrInterrupted.
+func TrainMNISTContext(ctx context.Context, network *ffnn.FFNetwork, options TrainingOptions) error {
+	learningRate := options.LearningRate
+	if learningRate == 0 {
+		learningRate = network.DefaultLearningRate()
+	}
+	return trainMNIST(ctx, &ffnn.Checkpoint{
+		Network: network, LearningRate: learningRate, Schedule: options.Schedule,
+	}, options)
+}
+
+
+// Continues the training from a checkpoint, with its learning
+//   rate, schedule and random state (the ones in the options are
+//   ignored), up to the total epochs in the options.
+func ResumeMNIST(checkpoint *ffnn.Checkpoint, options TrainingOptions) error {
+	return ResumeMNISTContext(context.Background(), checkpoint, options)
+}
+
+
+// Like ResumeMNIST, stopping as TrainMNISTContext does
+func ResumeMNISTContext(ctx context.Context, checkpoint *ffnn.Checkpoint, options TrainingOptions) error {
+	if options.Source != nil {
+		options.Source.SetState(checkpoint.RandomState)
+	}
+	fmt.Printf("Resuming from epoch %v, sample %v\n", checkpoint.Epoch, checkpoint.Sample)
+	return trainMNIST(ctx, checkpoint, options)
+}
+
+
+func trainMNIST(ctx context.Context, state *ffnn.Checkpoint, options TrainingOptions) error {
+	network := state.Network
+	session, err := newTrainingSession(state, options)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Starting the training with %v epocs\n", options.Epochs)
 	t1 := time.Now()
-	if testFile, err := os.Open(TestingFile); err == nil {
-		fmt.Println("Starting test.")
-		csvReader := csv.NewReader(bufio.NewReader(testFile))
-		scores := make([]float64, 64)
-		index := 0
-		first := true
-		for {
-			record, err := csvReader.Read()
-			if err == io.EOF {
-				break
+	for state.Epoch < options.Epochs {
+		if ctx.Err() != nil {
+			return interrupt(ctx, state, session)
+		}
+		learningRate := state.LearningRate
+		if state.Schedule != nil {
+			learningRate = state.Schedule.Rate(learningRate, state.Epoch)
+		}
+		fmt.Printf("Starting epoch: %v (learning rate: %v)\n", state.Epoch, learningRate)
+		sample := 0
+		err := readMNIST(options.Filename, func(record []string) error {
+			if err := ctx.Err(); err != nil {
+				return err
 			}
-
-			if first {
-				first = false
-				continue
+			// skipping the samples trained before the checkpoint
+			if sample < state.Sample {
+				sample++
+				return nil
 			}
-
-			inputs, expectedOutputs := makePair(record)
-			outputs, cost := network.Test(inputs, expectedOutputs)
-
-			// Get the highest output index
-			highestOutputIndex := -1
-			highestOutputValue := 0.0
-			for index := 0; index < 10; index++ {
-				value := outputs.At(index, 0)
-				if value > highestOutputValue {
-					highestOutputValue = value
-					highestOutputIndex = index
-				}
+			// train the NN with that data
+			image := makeImage(record)
+			if options.Augmentation != nil {
+				image = options.Augmentation.Augment(image, options.Random)
+			}
+			output, cost := network.TrainWithRate(flatten(image), makeTarget(record), learningRate)
+			sample++
+			state.Sample = sample
+			label, _ := strconv.Atoi(record[0])
+			if err := session.step(state, learningRate, cost, output, label); err != nil {
+				return err
+			}
+			if options.CheckpointEvery > 0 && sample % options.CheckpointEvery == 0 {
+				return saveCheckpoint(state, session)
 			}
+			return nil
+		})
+		if err != nil && ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+			return interrupt(ctx, state, session)
+		} else if err != nil {
+			return err
+		}
+		state.Epoch++
+		state.Sample = 0
+		fmt.Println("Epoch ended.")
 
-			// Tailing the cost
-			index++
-			if index == 64 {
-				index = 0
+		var validation *float64
+		improved := false
+		if options.ValidationFilename != "" {
+			if accuracy, better, err := validateMNIST(state, options); err != nil {
+				return err
+			} else {
+				validation, improved = &accuracy, better
+			}
+		}
+		if err := session.endEpoch(state, learningRate, validation); err != nil {
+			return err
+		}
+		if improved && options.BestFilename != "" {
+			if err := ffnn.Save(network, options.BestFilename); err != nil {
+				return fmt.Errorf("could not save the best network: %w", err)
 			}
-			scores[index] = cost
+			fmt.Println("Best network saved.")
+		}
+		if err := saveCheckpoint(state, session); err != nil {
+			return err
+		}
+	}
+	session.updateMetadata(state)
+	elapsed := time.Since(t1)
+	fmt.Printf("Training used %v epoch and took: %v\n", options.Epochs, elapsed)
+	return nil
+}
+
+
+func saveCheckpoint(state *ffnn.Checkpoint, session *trainingSession) error {
+	options := session.options
+	if options.Checkpoints == nil {
+		return nil
+	}
+	session.updateMetadata(state)
+	if options.Source != nil {
+		state.RandomState = options.Source.State()
+	}
+	if filename, err := options.Checkpoints.Save(state); err != nil {
+		return fmt.Errorf("could not save the checkpoint: %w", err)
+	} else {
+		fmt.Println("Checkpoint saved:", filename)
+	}
+	return nil
+}
+
+
+// Saves a checkpoint of the interrupted training, into the
+//   rotation or else into the interruption file
+func interrupt(ctx context.Context, state *ffnn.Checkpoint, session *trainingSession) error {
+	options := session.options
+	fmt.Printf("Training interrupted at epoch %v, sample %v.\n", state.Epoch, state.Sample)
+	if options.Checkpoints == nil && options.InterruptFilename != "" {
+		session.updateMetadata(state)
+		if options.Source != nil {
+			state.RandomState = options.Source.State()
+		}
+		if err := ffnn.SaveCheckpoint(state, options.InterruptFilename); err != nil {
+			return fmt.Errorf("could not save the checkpoint: %w", err)
+		}
+		fmt.Println("Checkpoint saved:", options.InterruptFilename)
+	} else if err := saveCheckpoint(state, session); err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: %v", ErrInterrupted, ctx.Err())
+}
+
+
+// Measures the accuracy against the validation file, keeping track
+//   of the best one. Tells whether it improved.
+func validateMNIST(state *ffnn.Checkpoint, options TrainingOptions) (float64, bool, error) {
+	classification := metrics.NewClassification(10, 1)
+	err := readMNIST(options.ValidationFilename, func(record []string) error {
+		expected, _ := strconv.Atoi(record[0])
+		return classification.Add(state.Network.Forward(makeInput(record)), expected)
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	accuracy := classification.Accuracy()
+	fmt.Printf("Validation accuracy: %v\n", accuracy)
+	if state.BestMetric != nil && accuracy <= *state.BestMetric {
+		return accuracy, false, nil
+	}
+	state.BestMetric, state.BestEpoch = &accuracy, state.Epoch
+	return accuracy, true, nil
+}
+
+
+func TrainMNISTNetwork(network *ffnn.FFNetwork, epochs int) {
+	TrainAugmentedMNISTNetwork(network, epochs, nil, nil)
+}
+
+
+// Trains the network, augmenting each image on-the-fly (before
+//   flattening it) with the given augmentation and generator.
+// A nil augmentation trains with the images as they are.
+func TrainAugmentedMNISTNetwork(network *ffnn.FFNetwork, epochs int, augmentation datasets.Augmentation, random *rand.Rand) {
+	if err := TrainMNIST(network, TrainingOptions{
+		Filename: TrainingFile, Epochs: epochs, Augmentation: augmentation, Random: random,
+	}); err != nil {
+		fmt.Printf("Training could not be completed! : %v\n", err)
+	}
+}
+
+
+type EvaluationOptions struct {
+	// The MNIST csv testing file
+	Filename string
+	// Whether to print each case
+	Verbose bool
+	// Whether to write the reports as JSON instead of text
+	JSON bool
+	Output io.Writer
+}
+
+
+type evaluationReport struct {
+	AverageCost    float64
+	Classification *metrics.ClassificationReport
+	Regression     *metrics.RegressionReport
+}
+
+
+func EvaluateMNIST(network *ffnn.FFNetwork, options EvaluationOptions) error {
+	output := options.Output
+	if output == nil {
+		output = os.Stdout
+	}
+
+	t1 := time.Now()
+	classification := metrics.NewClassification(10, 3)
+	regression := metrics.NewRegression(10)
+	totalCost := 0.0
+	err := readMNIST(options.Filename, func(record []string) error {
+		inputs, expectedOutputs := makePair(record)
+		outputs, cost := network.Test(inputs, expectedOutputs)
+		expected, _ := strconv.Atoi(record[0])
+		if err := classification.Add(outputs, expected); err != nil {
+			return err
+		}
+		regression.Add(outputs, expectedOutputs)
+		totalCost += cost
 
-			fmt.Printf("Case:\n  Expected: %v\n  Got: %v\n  Cost: %v\n", record[0], highestOutputIndex, cost)
+		if options.Verbose {
+			fmt.Fprintf(output, "Case:\n  Expected: %v\n  Got: %v\n  Cost: %v\n", expected, metrics.Argmax(outputs), cost)
 		}
-		elapsed := time.Since(t1)
-		fmt.Printf("Test ended. Time taken to check: %s\n", elapsed)
-		fmt.Printf("Last 64 scores: %v\n", scores)
-		fmt.Println("Avg score of last 64 cases:", mat.Sum(mat.NewDense(1, 64, scores)) / 64)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	report := evaluationReport{
+		Classification: classification.Report(),
+		Regression:     regression.Report(20),
+	}
+	if count := classification.Count(); count > 0 {
+		report.AverageCost = totalCost / float64(count)
+	}
+	if options.JSON {
+		encoder := json.NewEncoder(output)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(&report)
+	}
+
+	fmt.Fprintf(output, "Test ended. Time taken to check: %s\n", time.Since(t1))
+	fmt.Fprintln(output, "Avg cost:", report.AverageCost)
+	if err := report.Classification.WriteText(output); err != nil {
+		return err
 	}
-}
\ No newline at end of file
+	fmt.Fprintln(output, "Output regression metrics:")
+	return report.Regression.WriteText(output)
+}
+
+
+func TestMNISTNetwork(network *ffnn.FFNetwork) {
+	fmt.Println("Starting test.")
+	if err := EvaluateMNIST(network, EvaluationOptions{Filename: TestingFile, Verbose: true}); err != nil {
+		fmt.Printf("Test could not be completed! : %v\n", err)
+	}
+}
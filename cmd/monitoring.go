@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"../ffnn"
+	"../metrics"
+	"../monitoring"
+)
+
+
+// The gauges of a training run, updated with each history record
+type trainingMetrics struct {
+	loss         *monitoring.Gauge
+	accuracy     *monitoring.Gauge
+	validation   *monitoring.Gauge
+	learningRate *monitoring.Gauge
+	gradientNorm *monitoring.Gauge
+	epochs       *monitoring.Gauge
+	steps        *monitoring.Gauge
+	throughput   *monitoring.Gauge
+	// The step and wall time of the previous record, for the
+	//   throughput
+	lastStep     int
+	lastWallTime float64
+}
+
+func newTrainingMetrics(registry *monitoring.Registry, state *ffnn.Checkpoint) *trainingMetrics {
+	m := &trainingMetrics{
+		loss: registry.NewGauge("ffnn_training_loss", "Average loss of the last record, by kind: step or epoch.", "kind"),
+		accuracy: registry.NewGauge(
+			"ffnn_training_accuracy", "Average training accuracy of the last record, by kind: step or epoch.", "kind",
+		),
+		validation:   registry.NewGauge("ffnn_training_validation_accuracy", "Validation accuracy after the last epoch."),
+		learningRate: registry.NewGauge("ffnn_training_learning_rate", "Current learning rate."),
+		gradientNorm: registry.NewGauge("ffnn_training_gradient_norm", "Average gradient norm of the last record, by kind.", "kind"),
+		epochs:       registry.NewGauge("ffnn_training_epochs_completed", "Epochs completed."),
+		steps:        registry.NewGauge("ffnn_training_steps", "Training updates done by the network."),
+		throughput:   registry.NewGauge("ffnn_training_samples_per_second", "Samples trained per second since the previous record."),
+		lastStep:     state.Network.Step(),
+	}
+	m.epochs.Set(float64(state.Epoch))
+	m.steps.Set(float64(state.Network.Step()))
+	return m
+}
+
+func (m *trainingMetrics) record(record *metrics.HistoryRecord) {
+	m.loss.Set(record.Loss, record.Kind)
+	m.accuracy.Set(record.Accuracy, record.Kind)
+	m.learningRate.Set(record.LearningRate)
+	m.gradientNorm.Set(record.GradientNorm, record.Kind)
+	m.steps.Set(float64(record.Step))
+	if record.Kind == metrics.EpochRecord {
+		m.epochs.Set(float64(record.Epoch + 1))
+	}
+	if record.ValidationAccuracy != nil {
+		m.validation.Set(*record.ValidationAccuracy)
+	}
+	if elapsed := record.WallTime - m.lastWallTime; elapsed > 0 {
+		m.throughput.Set(float64(record.Step - m.lastStep) / elapsed)
+		m.lastStep, m.lastWallTime = record.Step, record.WallTime
+	}
+}
@@ -0,0 +1,512 @@
+package cmd
+
+import (
+	"../datasets"
+	"../ffnn"
+	"../metrics"
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gonum.org/v1/gonum/mat"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+
+// Parses a row of raw inputs. Rows having one extra column are
+//   considered to be labeled (as in the MNIST files), and their
+//   first column is ignored.
+func parseRow(record []string, inputSize int) (*mat.Dense, error) {
+	switch len(record) {
+	case inputSize + 1:
+		record = record[1:]
+	case inputSize:
+	default:
+		return nil, errors.New(fmt.Sprintf("expected %v (or %v labeled) columns, got %v", inputSize, inputSize + 1, len(record)))
+	}
+
+	inputs := make([]float64, inputSize)
+	for i := range inputs {
+		var err error
+		if inputs[i], err = strconv.ParseFloat(record[i], 64); err != nil {
+			return nil, err
+		}
+	}
+	return mat.NewDense(inputSize, 1, inputs), nil
+}
+
+
+// A source of inputs to predict, read one by one
+type InputReader interface {
+	// The next input, and what identifies it; io.EOF after the
+	//   last one
+	Next() (string, *mat.Dense, error)
+	// What the identifiers are: "row", "line" or "path"
+	IDName() string
+	Close() error
+}
+
+
+// The rows of a csv file (see parseRow), identified by their index.
+//   A non-numeric first row is considered a header and skipped.
+type csvInputs struct {
+	filename  string
+	file      *os.File
+	reader    *csv.Reader
+	inputSize int
+	row       int
+}
+
+func NewCSVInputs(filename string, inputSize int) (InputReader, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	reader := csv.NewReader(bufio.NewReader(file))
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	return &csvInputs{filename: filename, file: file, reader: reader, inputSize: inputSize, row: -1}, nil
+}
+
+func (inputs *csvInputs) Next() (string, *mat.Dense, error) {
+	for {
+		record, err := inputs.reader.Read()
+		if err != nil {
+			return "", nil, err
+		}
+		inputs.row++
+		input, err := parseRow(record, inputs.inputSize)
+		if err != nil {
+			if inputs.row == 0 {
+				continue
+			}
+			return "", nil, errors.New(fmt.Sprintf("%v: row %v: %v", inputs.filename, inputs.row, err))
+		}
+		return strconv.Itoa(inputs.row), input, nil
+	}
+}
+
+func (inputs *csvInputs) IDName() string {
+	return "row"
+}
+
+func (inputs *csvInputs) Close() error {
+	return inputs.file.Close()
+}
+
+
+// The lines of a JSON Lines file, each one either an array of
+//   values or an object with them as "input" (and, optionally, an
+//   "id"). They are identified by their id or else their (1-based)
+//   line number. Blank lines are skipped.
+type jsonLinesInputs struct {
+	filename  string
+	file      *os.File
+	scanner   *bufio.Scanner
+	inputSize int
+	line      int
+}
+
+// The longest line accepted
+const maxJSONLine = 64 << 20
+
+func NewJSONLinesInputs(filename string, inputSize int) (InputReader, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, maxJSONLine)
+	return &jsonLinesInputs{filename: filename, file: file, scanner: scanner, inputSize: inputSize}, nil
+}
+
+func (inputs *jsonLinesInputs) Next() (string, *mat.Dense, error) {
+	for inputs.scanner.Scan() {
+		inputs.line++
+		line := bytes.TrimSpace(inputs.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		id, values, err := parseJSONInput(line)
+		if err == nil && len(values) != inputs.inputSize {
+			err = errors.New(fmt.Sprintf("expected %v values, got %v", inputs.inputSize, len(values)))
+		}
+		if err != nil {
+			return "", nil, errors.New(fmt.Sprintf("%v: line %v: %v", inputs.filename, inputs.line, err))
+		}
+		if id == "" {
+			id = strconv.Itoa(inputs.line)
+		}
+		return id, mat.NewDense(len(values), 1, values), nil
+	}
+	if err := inputs.scanner.Err(); err != nil {
+		return "", nil, fmt.Errorf("%v: line %v: %w", inputs.filename, inputs.line + 1, err)
+	}
+	return "", nil, io.EOF
+}
+
+func parseJSONInput(line []byte) (string, []float64, error) {
+	var values []float64
+	if line[0] == '[' {
+		err := json.Unmarshal(line, &values)
+		return "", values, err
+	}
+	var object struct {
+		ID    interface{}
+		Input []float64
+	}
+	if err := json.Unmarshal(line, &object); err != nil {
+		return "", nil, err
+	}
+	if object.Input == nil {
+		return "", nil, errors.New("no \"input\" values")
+	}
+	id := ""
+	if object.ID != nil {
+		id = fmt.Sprint(object.ID)
+	}
+	return id, object.Input, nil
+}
+
+func (inputs *jsonLinesInputs) IDName() string {
+	return "line"
+}
+
+func (inputs *jsonLinesInputs) Close() error {
+	return inputs.file.Close()
+}
+
+
+// The images in a folder and its subfolders, in lexical order,
+//   identified by their path relative to the folder. Only their
+//   paths are kept; each image is loaded when read.
+type imageInputs struct {
+	root    string
+	paths   []string
+	next    int
+	options datasets.ImageFolderOptions
+}
+
+// Without a width and height, the images are resized to the square
+//   taking the network inputs
+func NewImageInputs(root string, inputSize int, options datasets.ImageFolderOptions) (InputReader, error) {
+	if options.Width == 0 && options.Height == 0 {
+		side := int(math.Round(math.Sqrt(float64(inputSize / options.Mode.Channels()))))
+		options.Width, options.Height = side, side
+	}
+	if options.InputSize() != inputSize {
+		return nil, errors.New(fmt.Sprintf(
+			"the images give %v values (%vx%v, %v channels), but the network takes %v",
+			options.InputSize(), options.Width, options.Height, options.Mode.Channels(), inputSize,
+		))
+	}
+	inputs := &imageInputs{root: root, options: options}
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() && datasets.IsImageFile(path) {
+			inputs.paths = append(inputs.paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return inputs, nil
+}
+
+func (inputs *imageInputs) Next() (string, *mat.Dense, error) {
+	if inputs.next >= len(inputs.paths) {
+		return "", nil, io.EOF
+	}
+	path := inputs.paths[inputs.next]
+	inputs.next++
+	input, err := datasets.LoadImage(path, inputs.options)
+	if err != nil {
+		return "", nil, err
+	}
+	id, _ := filepath.Rel(inputs.root, path)
+	return filepath.ToSlash(id), input, nil
+}
+
+func (inputs *imageInputs) IDName() string {
+	return "path"
+}
+
+func (inputs *imageInputs) Close() error {
+	return nil
+}
+
+
+// Opens the inputs, by their format: "csv", "jsonl" or "images".
+//   When empty, directories are taken as images, ".jsonl" and
+//   ".json" files as JSON Lines, and the rest as csv.
+func OpenInputs(path, format string, inputSize int, imageOptions datasets.ImageFolderOptions) (InputReader, error) {
+	if format == "" {
+		if info, err := os.Stat(path); err != nil {
+			return nil, err
+		} else if info.IsDir() {
+			format = "images"
+		} else if extension := strings.ToLower(filepath.Ext(path)); extension == ".jsonl" || extension == ".json" {
+			format = "jsonl"
+		} else {
+			format = "csv"
+		}
+	}
+	switch format {
+	case "csv":
+		return NewCSVInputs(path, inputSize)
+	case "jsonl":
+		return NewJSONLinesInputs(path, inputSize)
+	case "images":
+		return NewImageInputs(path, inputSize, imageOptions)
+	}
+	return nil, errors.New(fmt.Sprintf("unknown input format: %v (expected csv, jsonl or images)", format))
+}
+
+
+// What is written for each prediction: the label of the highest
+//   output always, plus all the outputs ("raw") or the k most
+//   probable labels ("topk")
+const (
+	ArgmaxPredictions = "argmax"
+	RawPredictions    = "raw"
+	TopKPredictions   = "topk"
+)
+
+type PredictionOptions struct {
+	// ArgmaxPredictions (default), RawPredictions or
+	//   TopKPredictions
+	Mode string
+	// The labels in the top-k ones
+	K int
+	// Names of the outputs (by default, their indices)
+	Labels []string
+	// Inputs predicted at once, concurrently (by default, 256)
+	BatchSize int
+}
+
+const defaultBatchSize = 256
+
+func (options PredictionOptions) validate(outputSize int) (PredictionOptions, error) {
+	switch options.Mode {
+	case "":
+		options.Mode = ArgmaxPredictions
+	case ArgmaxPredictions, RawPredictions:
+	case TopKPredictions:
+		if options.K < 1 {
+			return options, errors.New("top-k needs k >= 1")
+		}
+		if options.K > outputSize {
+			options.K = outputSize
+		}
+	default:
+		return options, errors.New(fmt.Sprintf(
+			"unknown prediction mode: %v (expected %v, %v or %v)", options.Mode, ArgmaxPredictions, RawPredictions, TopKPredictions,
+		))
+	}
+	if options.Labels == nil {
+		options.Labels = make([]string, outputSize)
+		for index := range options.Labels {
+			options.Labels[index] = strconv.Itoa(index)
+		}
+	} else if len(options.Labels) != outputSize {
+		return options, errors.New(fmt.Sprintf("got %v labels for %v outputs", len(options.Labels), outputSize))
+	}
+	if options.BatchSize == 0 {
+		options.BatchSize = defaultBatchSize
+	} else if options.BatchSize < 0 {
+		return options, errors.New("the batch size must be >= 1")
+	}
+	return options, nil
+}
+
+
+type LabelProbability struct {
+	Label       string  `json:"label"`
+	Probability float64 `json:"probability"`
+}
+
+type Prediction struct {
+	ID         string             `json:"id"`
+	Prediction string             `json:"prediction"`
+	Outputs    []float64          `json:"outputs,omitempty"`
+	Top        []LabelProbability `json:"top,omitempty"`
+}
+
+func (options PredictionOptions) prediction(id string, output *mat.Dense) *Prediction {
+	prediction := &Prediction{ID: id, Prediction: options.Labels[metrics.Argmax(output)]}
+	switch options.Mode {
+	case RawPredictions:
+		prediction.Outputs = mat.Col(nil, 0, output)
+	case TopKPredictions:
+		probabilities := metrics.Probabilities(output)
+		for _, index := range metrics.TopK(output, options.K) {
+			prediction.Top = append(prediction.Top, LabelProbability{options.Labels[index], probabilities[index]})
+		}
+	}
+	return prediction
+}
+
+
+// Writes the predictions somewhere, one by one. Close flushes them
+//   (but does not close the writer).
+type PredictionWriter interface {
+	Write(prediction *Prediction) error
+	Close() error
+}
+
+// One JSON object per line
+type jsonLinesPredictions struct {
+	encoder *json.Encoder
+}
+
+func NewJSONLinesPredictions(w io.Writer) PredictionWriter {
+	return &jsonLinesPredictions{encoder: json.NewEncoder(w)}
+}
+
+func (predictions *jsonLinesPredictions) Write(prediction *Prediction) error {
+	return predictions.encoder.Encode(prediction)
+}
+
+func (predictions *jsonLinesPredictions) Close() error {
+	return nil
+}
+
+
+// CSV with a header: the id and "prediction" columns, followed by
+//   the outputs (named after their labels) or the top-k
+//   "label_<n>" and "probability_<n>" columns
+type csvPredictions struct {
+	writer *csv.Writer
+}
+
+func NewCSVPredictions(w io.Writer, idName string, options PredictionOptions) PredictionWriter {
+	writer := csv.NewWriter(w)
+	header := []string{idName, "prediction"}
+	switch options.Mode {
+	case RawPredictions:
+		header = append(header, options.Labels...)
+	case TopKPredictions:
+		for n := 1; n <= options.K; n++ {
+			header = append(header, fmt.Sprintf("label_%v", n), fmt.Sprintf("probability_%v", n))
+		}
+	}
+	// Buffered: any error shows when writing the predictions
+	writer.Write(header)
+	return &csvPredictions{writer: writer}
+}
+
+func (predictions *csvPredictions) Write(prediction *Prediction) error {
+	format := func(value float64) string {
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+	record := []string{prediction.ID, prediction.Prediction}
+	for _, value := range prediction.Outputs {
+		record = append(record, format(value))
+	}
+	for _, top := range prediction.Top {
+		record = append(record, top.Label, format(top.Probability))
+	}
+	return predictions.writer.Write(record)
+}
+
+func (predictions *csvPredictions) Close() error {
+	predictions.writer.Flush()
+	return predictions.writer.Error()
+}
+
+// Creates the prediction writer for a file name: JSON Lines for
+//   ".jsonl" or ".json", and csv for the rest (and no file name)
+func NewPredictionsFor(filename string, w io.Writer, idName string, options PredictionOptions) PredictionWriter {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".jsonl", ".json":
+		return NewJSONLinesPredictions(w)
+	}
+	return NewCSVPredictions(w, idName, options)
+}
+
+
+// Predicts all the inputs and writes their predictions, in the same
+//   order. The inputs are read (and predicted concurrently) in
+//   batches, so only a batch is kept in memory. Returns how many
+//   were predicted.
+func PredictAll(network *ffnn.FFNetwork, inputs InputReader, newWriter func(PredictionOptions) PredictionWriter, options PredictionOptions) (int, error) {
+	outputSize := network.Layer(network.LayersCount() - 1).OutputSize()
+	options, err := options.validate(outputSize)
+	if err != nil {
+		return 0, err
+	}
+	writer := newWriter(options)
+
+	ids := make([]string, 0, options.BatchSize)
+	batch := make([]*mat.Dense, 0, options.BatchSize)
+	predictions := make([]*Prediction, options.BatchSize)
+	count := 0
+	for done := false; !done; {
+		ids, batch = ids[:0], batch[:0]
+		for len(batch) < options.BatchSize {
+			id, input, err := inputs.Next()
+			if err == io.EOF {
+				done = true
+				break
+			} else if err != nil {
+				writer.Close()
+				return count, err
+			}
+			ids, batch = append(ids, id), append(batch, input)
+		}
+
+		// Each worker takes every workers-th input of the batch
+		workers := runtime.GOMAXPROCS(0)
+		if workers > len(batch) {
+			workers = len(batch)
+		}
+		var group sync.WaitGroup
+		for worker := 0; worker < workers; worker++ {
+			group.Add(1)
+			go func(worker int) {
+				defer group.Done()
+				for index := worker; index < len(batch); index += workers {
+					predictions[index] = options.prediction(ids[index], network.Predict(batch[index]))
+				}
+			}(worker)
+		}
+		group.Wait()
+
+		for _, prediction := range predictions[:len(batch)] {
+			if err := writer.Write(prediction); err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+	return count, writer.Close()
+}
+
+
+// Writes, for each row in the csv file, its index and the index
+//   of the highest output. A non-numeric first row is considered
+//   a header and skipped.
+func Predict(network *ffnn.FFNetwork, filename string, output io.Writer) error {
+	inputs, err := NewCSVInputs(filename, network.InputSize())
+	if err != nil {
+		return err
+	}
+	defer inputs.Close()
+	_, err = PredictAll(network, inputs, func(options PredictionOptions) PredictionWriter {
+		return NewCSVPredictions(output, inputs.IDName(), options)
+	}, PredictionOptions{})
+	return err
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"../ffnn"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+
+// Parses a learning rate schedule given as `Name` or
+//   `Name:key=value,...` (e.g. "StepDecay:every=2,factor=0.5"),
+//   where the keys are the schedule parameters. An empty spec is a
+//   constant rate (nil).
+func ParseSchedule(spec string) (ffnn.Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+	fields := strings.SplitN(spec, ":", 2)
+	schedule, err := ffnn.NewSchedule(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 1 {
+		return schedule, nil
+	}
+
+	params := map[string]float64{}
+	for _, pair := range strings.Split(fields[1], ",") {
+		keyValue := strings.SplitN(pair, "=", 2)
+		if len(keyValue) != 2 {
+			return nil, errors.New(fmt.Sprintf("invalid schedule parameter %q (expected key=value)", pair))
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(keyValue[1]), 64)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid value for the schedule parameter %q", keyValue[0]))
+		}
+		params[strings.TrimSpace(keyValue[0])] = value
+	}
+	data, _ := json.Marshal(params)
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(schedule); err != nil {
+		return nil, errors.New(fmt.Sprintf("invalid parameters for the %v schedule: %v", schedule.Name(), err))
+	}
+	return schedule, nil
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"../ffnn"
+	"../metrics"
+	"../tensorboard"
+	"fmt"
+)
+
+
+// The activations are sampled every this many steps, for their
+//   histograms at the end of each epoch
+const activationsEvery = 100
+
+
+// Writes the record's scalars, tagged "<kind>/<name>" and placed at
+//   the record's step
+func writeScalars(writer *tensorboard.Writer, record *metrics.HistoryRecord) error {
+	scalars := map[string]float64{
+		"loss": record.Loss, "accuracy": record.Accuracy,
+		"learning_rate": record.LearningRate, "gradient_norm": record.GradientNorm,
+	}
+	if record.ValidationAccuracy != nil {
+		scalars["validation_accuracy"] = *record.ValidationAccuracy
+	}
+	for _, name := range sortedKeys(scalars) {
+		if err := writer.Scalar(record.Kind + "/" + name, record.Step, scalars[name]); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+
+// Keeps the activations of each layer, as sampled along the epoch
+type activationSamples [][]float64
+
+func (samples *activationSamples) add(network *ffnn.FFNetwork) {
+	if *samples == nil {
+		*samples = make(activationSamples, network.LayersCount())
+	}
+	for index := range *samples {
+		(*samples)[index] = append((*samples)[index], network.Layer(index).Activations().RawMatrix().Data...)
+	}
+}
+
+// Writes the histograms of the weights of each layer, and of the
+//   sampled activations (which are reset)
+func (samples *activationSamples) writeHistograms(writer *tensorboard.Writer, network *ffnn.FFNetwork) error {
+	step := network.Step()
+	for index := 0; index < network.LayersCount(); index++ {
+		// The weights are contiguous, since the layers create them
+		weights := network.Layer(index).Weights().RawMatrix().Data
+		if err := writer.Histogram(fmt.Sprintf("layer_%v/weights", index), step, weights); err != nil {
+			return err
+		}
+		if *samples == nil {
+			continue
+		}
+		if err := writer.Histogram(fmt.Sprintf("layer_%v/activations", index), step, (*samples)[index]); err != nil {
+			return err
+		}
+	}
+	*samples = nil
+	return writer.Flush()
+}
@@ -0,0 +1,263 @@
+package dashboard
+
+import (
+	"../ffnn"
+	"../metrics"
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"sync"
+)
+
+//go:embed static
+var static embed.FS
+
+// How many step records are kept for the clients connecting late
+//   (the epoch ones are all kept)
+const maxStepRecords = 5000
+
+// How many updates can wait for a client. Slower clients are
+//   disconnected (and their browsers reconnect, getting the state
+//   anew), so the training never waits for them.
+const pendingUpdates = 64
+
+
+// Statistics of the weights of a layer
+type LayerStats struct {
+	Layer     int            `json:"layer"`
+	Rows      int            `json:"rows"`
+	Columns   int            `json:"columns"`
+	Activator string         `json:"activator"`
+	Mean      metrics.Number `json:"mean"`
+	Std       metrics.Number `json:"std"`
+	Min       metrics.Number `json:"min"`
+	Max       metrics.Number `json:"max"`
+	// The Frobenius norm
+	Norm metrics.Number `json:"norm"`
+}
+
+func layerStats(network *ffnn.FFNetwork) []LayerStats {
+	stats := make([]LayerStats, network.LayersCount())
+	for index := range stats {
+		layer := network.Layer(index)
+		rows, columns := layer.Weights().Dims()
+		weights := ffnn.MatrixStats(layer.Weights())
+		stats[index] = LayerStats{
+			Layer: index, Rows: rows, Columns: columns, Activator: layer.Activator().Name(),
+			Mean: metrics.Number(weights.Mean), Std: metrics.Number(weights.Std),
+			Min: metrics.Number(weights.Min), Max: metrics.Number(weights.Max), Norm: metrics.Number(weights.L2Norm),
+		}
+	}
+	return stats
+}
+
+
+// The confusion matrix, indexed as [expected][predicted], and what
+//   it was measured on
+type Confusion struct {
+	Source string   `json:"source"`
+	Labels []string `json:"labels"`
+	Matrix [][]int  `json:"matrix"`
+}
+
+// Everything shown, as sent to the clients when they connect
+type state struct {
+	Records   []*metrics.HistoryRecord `json:"records"`
+	Layers    []LayerStats             `json:"layers"`
+	Confusion *Confusion               `json:"confusion"`
+}
+
+
+// A local web page showing the training progress live, updated
+//   through Server-Sent Events. The training loop publishes to it
+//   (so the network is only read from the training goroutine), and
+//   it is safe for concurrent use.
+type Dashboard struct {
+	mutex       sync.Mutex
+	state       state
+	stepRecords int
+	subscribers map[chan []byte]bool
+	server      *http.Server
+}
+
+func New() *Dashboard {
+	return &Dashboard{subscribers: map[chan []byte]bool{}}
+}
+
+// The page ("/"), the current state ("/state") and the updates
+//   ("/events")
+func (dashboard *Dashboard) Handler() http.Handler {
+	content, _ := fs.Sub(static, "static")
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServer(http.FS(content)))
+	mux.HandleFunc("/state", dashboard.serveState)
+	mux.HandleFunc("/events", dashboard.serveEvents)
+	return mux
+}
+
+// Serves the dashboard in the background. Addresses without host
+//   (e.g. ":8080") listen on localhost only. Returns the URL.
+func (dashboard *Dashboard) Start(address string) (string, error) {
+	if host, port, err := net.SplitHostPort(address); err != nil {
+		return "", err
+	} else if host == "" {
+		address = net.JoinHostPort("localhost", port)
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return "", err
+	}
+	server := &http.Server{Handler: dashboard.Handler()}
+	dashboard.mutex.Lock()
+	dashboard.server = server
+	dashboard.mutex.Unlock()
+	go server.Serve(listener)
+	return fmt.Sprintf("http://%v/", listener.Addr()), nil
+}
+
+// Stops serving, disconnecting the clients
+func (dashboard *Dashboard) Close() error {
+	dashboard.mutex.Lock()
+	server := dashboard.server
+	dashboard.server = nil
+	for subscriber := range dashboard.subscribers {
+		delete(dashboard.subscribers, subscriber)
+		close(subscriber)
+	}
+	dashboard.mutex.Unlock()
+	if server == nil {
+		return nil
+	}
+	return server.Shutdown(context.Background())
+}
+
+
+// Publishes a history record
+func (dashboard *Dashboard) Record(record *metrics.HistoryRecord) {
+	copied := *record
+	dashboard.mutex.Lock()
+	defer dashboard.mutex.Unlock()
+	records := append(dashboard.state.Records, &copied)
+	if copied.Kind == metrics.StepRecord {
+		dashboard.stepRecords++
+		if dashboard.stepRecords > maxStepRecords {
+			// Dropping the oldest step record
+			for index, old := range records {
+				if old.Kind == metrics.StepRecord {
+					records = append(records[:index], records[index + 1:]...)
+					break
+				}
+			}
+			dashboard.stepRecords--
+		}
+	}
+	dashboard.state.Records = records
+	dashboard.publish("record", &copied)
+}
+
+// Publishes the statistics of the layers of the network. It must
+//   be called from the goroutine training it.
+func (dashboard *Dashboard) Layers(network *ffnn.FFNetwork) {
+	stats := layerStats(network)
+	dashboard.mutex.Lock()
+	defer dashboard.mutex.Unlock()
+	dashboard.state.Layers = stats
+	dashboard.publish("layers", stats)
+}
+
+// Publishes the confusion matrix of the classification
+func (dashboard *Dashboard) Confusion(source string, classification *metrics.Classification) {
+	// The report copies the matrix
+	report := classification.Report()
+	confusion := &Confusion{Source: source, Matrix: report.ConfusionMatrix}
+	for _, class := range report.Classes {
+		confusion.Labels = append(confusion.Labels, class.Label)
+	}
+	dashboard.mutex.Lock()
+	defer dashboard.mutex.Unlock()
+	dashboard.state.Confusion = confusion
+	dashboard.publish("confusion", confusion)
+}
+
+// Sends the event to the subscribers, dropping the slow ones. The
+//   mutex must be held.
+func (dashboard *Dashboard) publish(event string, data interface{}) {
+	if len(dashboard.subscribers) == 0 {
+		return
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	message := []byte(fmt.Sprintf("event: %v\ndata: %s\n\n", event, encoded))
+	for subscriber := range dashboard.subscribers {
+		select {
+		case subscriber <- message:
+		default:
+			delete(dashboard.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+}
+
+
+func (dashboard *Dashboard) serveState(w http.ResponseWriter, r *http.Request) {
+	dashboard.mutex.Lock()
+	encoded, err := json.Marshal(&dashboard.state)
+	dashboard.mutex.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(encoded)
+}
+
+func (dashboard *Dashboard) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	// The state first, then its updates
+	subscriber := make(chan []byte, pendingUpdates)
+	dashboard.mutex.Lock()
+	encoded, err := json.Marshal(&dashboard.state)
+	dashboard.subscribers[subscriber] = true
+	dashboard.mutex.Unlock()
+	defer func() {
+		dashboard.mutex.Lock()
+		if dashboard.subscribers[subscriber] {
+			delete(dashboard.subscribers, subscriber)
+			close(subscriber)
+		}
+		dashboard.mutex.Unlock()
+	}()
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "event: state\ndata: %s\n\n", encoded)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case message, open := <-subscriber:
+			if !open {
+				return
+			}
+			if _, err := w.Write(message); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
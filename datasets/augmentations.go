@@ -0,0 +1,258 @@
+package datasets
+
+import (
+	"math"
+	"math/rand"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Intensity range of the raw images being augmented.
+const pixelMax = 255.0
+
+// An augmentation transforms a single (rows x columns) image,
+//   with raw [0, 255] intensities, BEFORE it is flattened into
+//   an input column. All the randomness MUST come from the
+//   given generator, so the augmentations are reproducible for
+//   a given seed. The result may be a new matrix or the same
+//   given image.
+type Augmentation interface {
+	Augment(image *mat.Dense, random *rand.Rand) *mat.Dense
+}
+
+// Applies several augmentations, in order
+type Pipeline []Augmentation
+
+func (pipeline Pipeline) Augment(image *mat.Dense, random *rand.Rand) *mat.Dense {
+	for _, augmentation := range pipeline {
+		image = augmentation.Augment(image, random)
+	}
+	return image
+}
+
+// Applies the wrapped augmentation only with a given probability
+type Sometimes struct {
+	Probability  float64
+	Augmentation Augmentation
+}
+
+func (s Sometimes) Augment(image *mat.Dense, random *rand.Rand) *mat.Dense {
+	if random.Float64() < s.Probability {
+		return s.Augmentation.Augment(image, random)
+	}
+	return image
+}
+
+// Moves the image up to MaxShift pixels in each axis (its sign
+//   is ignored)
+type RandomShift struct {
+	MaxShift int
+}
+
+func (s RandomShift) Augment(image *mat.Dense, random *rand.Rand) *mat.Dense {
+	maxShift := s.MaxShift
+	if maxShift < 0 {
+		maxShift = -maxShift
+	}
+	dx := float64(random.Intn(2*maxShift+1) - maxShift)
+	dy := float64(random.Intn(2*maxShift+1) - maxShift)
+	return remap(image, func(x, y float64) (float64, float64) {
+		return x - dx, y - dy
+	})
+}
+
+// Rotates the image around its center up to MaxDegrees in
+//   each direction
+type RandomRotation struct {
+	MaxDegrees float64
+}
+
+func (r RandomRotation) Augment(image *mat.Dense, random *rand.Rand) *mat.Dense {
+	angle := (random.Float64()*2 - 1) * r.MaxDegrees * math.Pi / 180
+	cos, sin := math.Cos(angle), math.Sin(angle)
+	cx, cy := center(image)
+	// Inverse mapping: rotate the target coordinates backwards
+	return remap(image, func(x, y float64) (float64, float64) {
+		x, y = x-cx, y-cy
+		return cos*x + sin*y + cx, -sin*x + cos*y + cy
+	})
+}
+
+// Zooms the image around its center by a factor in [Min, Max]
+type RandomScale struct {
+	Min float64
+	Max float64
+}
+
+func (s RandomScale) Augment(image *mat.Dense, random *rand.Rand) *mat.Dense {
+	factor := s.Min + random.Float64()*(s.Max-s.Min)
+	if factor <= 0 {
+		return image
+	}
+	cx, cy := center(image)
+	return remap(image, func(x, y float64) (float64, float64) {
+		return (x-cx)/factor + cx, (y-cy)/factor + cy
+	})
+}
+
+// Elastic distortion as described by Simard et al.: random
+//   displacement fields smoothed by a gaussian of deviation
+//   Sigma and scaled by Alpha
+type ElasticDistortion struct {
+	Alpha float64
+	Sigma float64
+}
+
+func (e ElasticDistortion) Augment(image *mat.Dense, random *rand.Rand) *mat.Dense {
+	rows, columns := image.Dims()
+	dx := smoothField(rows, columns, e.Sigma, random)
+	dy := smoothField(rows, columns, e.Sigma, random)
+	return remap(image, func(x, y float64) (float64, float64) {
+		i, j := int(y), int(x)
+		return x + e.Alpha*dx[i*columns+j], y + e.Alpha*dy[i*columns+j]
+	})
+}
+
+// Adds gaussian noise of deviation StdDev (in the [0, 255]
+//   scale) and clips the result
+type GaussianNoise struct {
+	StdDev float64
+}
+
+func (g GaussianNoise) Augment(image *mat.Dense, random *rand.Rand) *mat.Dense {
+	rows, columns := image.Dims()
+	result := mat.NewDense(rows, columns, nil)
+	result.Apply(func(i, j int, v float64) float64 {
+		return clip(v + random.NormFloat64()*g.StdDev)
+	}, image)
+	return result
+}
+
+// With a given probability, blanks a random rectangle covering
+//   between MinArea and MaxArea of the image (as fractions)
+type RandomErasing struct {
+	Probability float64
+	MinArea     float64
+	MaxArea     float64
+	// Value to fill the rectangle with
+	Value float64
+}
+
+func (e RandomErasing) Augment(image *mat.Dense, random *rand.Rand) *mat.Dense {
+	if random.Float64() >= e.Probability {
+		return image
+	}
+	rows, columns := image.Dims()
+	area := (e.MinArea + random.Float64()*(e.MaxArea-e.MinArea)) * float64(rows*columns)
+	// Aspect ratio between 1/2 and 2
+	aspect := math.Exp((random.Float64()*2 - 1) * math.Ln2)
+	height := int(math.Min(float64(rows), math.Round(math.Sqrt(area*aspect))))
+	width := int(math.Min(float64(columns), math.Round(math.Sqrt(area/aspect))))
+	if height < 1 || width < 1 {
+		return image
+	}
+	top := random.Intn(rows - height + 1)
+	left := random.Intn(columns - width + 1)
+
+	result := mat.DenseCopyOf(image)
+	for i := top; i < top+height; i++ {
+		for j := left; j < left+width; j++ {
+			result.Set(i, j, e.Value)
+		}
+	}
+	return result
+}
+
+// A reasonable default pipeline for MNIST-like digits
+func DefaultDigitAugmentation() Augmentation {
+	return Pipeline{
+		Sometimes{0.5, RandomRotation{MaxDegrees: 10}},
+		Sometimes{0.5, RandomScale{Min: 0.9, Max: 1.1}},
+		Sometimes{0.5, RandomShift{MaxShift: 2}},
+		Sometimes{0.3, ElasticDistortion{Alpha: 8, Sigma: 3}},
+		Sometimes{0.3, GaussianNoise{StdDev: 8}},
+		RandomErasing{Probability: 0.1, MinArea: 0.02, MaxArea: 0.1},
+	}
+}
+
+func center(image *mat.Dense) (float64, float64) {
+	rows, columns := image.Dims()
+	return float64(columns-1) / 2, float64(rows-1) / 2
+}
+
+func clip(v float64) float64 {
+	return math.Max(0, math.Min(pixelMax, v))
+}
+
+// Builds a new image by sampling, for each target (x, y), the
+//   source coordinates given by the inverse mapping. Samples
+//   are bilinear, and out-of-bounds pixels count as 0.
+func remap(image *mat.Dense, source func(x, y float64) (float64, float64)) *mat.Dense {
+	rows, columns := image.Dims()
+	result := mat.NewDense(rows, columns, nil)
+	at := func(i, j int) float64 {
+		if i < 0 || j < 0 || i >= rows || j >= columns {
+			return 0
+		}
+		return image.At(i, j)
+	}
+	for i := 0; i < rows; i++ {
+		for j := 0; j < columns; j++ {
+			sx, sy := source(float64(j), float64(i))
+			x0, y0 := math.Floor(sx), math.Floor(sy)
+			fx, fy := sx-x0, sy-y0
+			j0, i0 := int(x0), int(y0)
+			top := at(i0, j0)*(1-fx) + at(i0, j0+1)*fx
+			bottom := at(i0+1, j0)*(1-fx) + at(i0+1, j0+1)*fx
+			result.Set(i, j, clip(top*(1-fy)+bottom*fy))
+		}
+	}
+	return result
+}
+
+// A uniform [-1, 1] random field convolved with a (separable)
+//   gaussian kernel
+func smoothField(rows, columns int, sigma float64, random *rand.Rand) []float64 {
+	field := make([]float64, rows*columns)
+	for index := range field {
+		field[index] = random.Float64()*2 - 1
+	}
+	if sigma <= 0 {
+		return field
+	}
+
+	radius := int(math.Ceil(3 * sigma))
+	kernel := make([]float64, 2*radius+1)
+	sum := 0.0
+	for k := range kernel {
+		d := float64(k - radius)
+		kernel[k] = math.Exp(-d * d / (2 * sigma * sigma))
+		sum += kernel[k]
+	}
+	for k := range kernel {
+		kernel[k] /= sum
+	}
+
+	convolve := func(src []float64, horizontal bool) []float64 {
+		dst := make([]float64, len(src))
+		for i := 0; i < rows; i++ {
+			for j := 0; j < columns; j++ {
+				value := 0.0
+				for k, weight := range kernel {
+					ii, jj := i, j
+					if horizontal {
+						jj += k - radius
+					} else {
+						ii += k - radius
+					}
+					if ii >= 0 && jj >= 0 && ii < rows && jj < columns {
+						value += weight * src[ii*columns+jj]
+					}
+				}
+				dst[i*columns+j] = value
+			}
+		}
+		return dst
+	}
+	return convolve(convolve(field, true), false)
+}
@@ -0,0 +1,316 @@
+package datasets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// How the decoded images are converted before flattening.
+// Grayscale images produce one value per pixel, while RGB
+//   images produce three interleaved values (r, g, b) per
+//   pixel.
+type ColorMode int
+
+const (
+	Grayscale ColorMode = iota
+	RGB
+)
+
+func (mode ColorMode) Channels() int {
+	if mode == RGB {
+		return 3
+	}
+	return 1
+}
+
+type ImageFolderOptions struct {
+	// Fixed size all the images will be resized to
+	Width  int
+	Height int
+	// Grayscale (default) or RGB
+	Mode ColorMode
+	// Range the [0, 255] intensities are mapped into. When both
+	//   are zero, the MNIST-like [0.01, 1.0] range is used
+	Low  float64
+	High float64
+	// Values for the one-hot targets. When both are zero, the
+	//   MNIST-like 0.01 / 0.99 values are used
+	TargetLow  float64
+	TargetHigh float64
+}
+
+func (options ImageFolderOptions) normalized() (ImageFolderOptions, error) {
+	if options.Width < 1 || options.Height < 1 {
+		return options, errors.New("image width and height must be >= 1")
+	}
+	if options.Mode != Grayscale && options.Mode != RGB {
+		return options, errors.New("unknown color mode")
+	}
+	if options.Low == 0 && options.High == 0 {
+		options.Low, options.High = 0.01, 1.0
+	}
+	if options.TargetLow == 0 && options.TargetHigh == 0 {
+		options.TargetLow, options.TargetHigh = 0.01, 0.99
+	}
+	return options, nil
+}
+
+// The size of the input columns generated with these options
+func (options ImageFolderOptions) InputSize() int {
+	return options.Width * options.Height * options.Mode.Channels()
+}
+
+type Sample struct {
+	// Source file of this sample
+	Path string
+	// Index of the label in the folder's Labels
+	Label int
+	// Size: (inputSize rows, 1 column)
+	Input *mat.Dense
+	// Size: (len(labels) rows, 1 column)
+	Target *mat.Dense
+}
+
+// A dataset of images laid out as root/<label>/<image>.
+// Labels are sorted by name, and their indices are the ones
+//   used in the targets (and expected in the predictions).
+type ImageFolder struct {
+	Options ImageFolderOptions
+	Labels  []string
+	Samples []*Sample
+}
+
+var imageExtensions = map[string]bool{
+	".png":  true,
+	".jpg":  true,
+	".jpeg": true,
+	".gif":  true,
+}
+
+// Whether the file is an image that can be loaded, by its extension
+func IsImageFile(filename string) bool {
+	return imageExtensions[strings.ToLower(filepath.Ext(filename))]
+}
+
+func LoadImageFolder(root string, options ImageFolderOptions) (*ImageFolder, error) {
+	var err error
+	if options, err = options.normalized(); err != nil {
+		return nil, err
+	}
+
+	var entries []os.FileInfo
+	if entries, err = ioutil.ReadDir(root); err != nil {
+		return nil, err
+	}
+
+	folder := &ImageFolder{
+		Options: options,
+		Labels:  make([]string, 0),
+		Samples: make([]*Sample, 0),
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			folder.Labels = append(folder.Labels, entry.Name())
+		}
+	}
+	if len(folder.Labels) == 0 {
+		return nil, errors.New(fmt.Sprintf("no label directories found in %v", root))
+	}
+	sort.Strings(folder.Labels)
+
+	for label, name := range folder.Labels {
+		var files []os.FileInfo
+		if files, err = ioutil.ReadDir(filepath.Join(root, name)); err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if file.IsDir() || !IsImageFile(file.Name()) {
+				continue
+			}
+			path := filepath.Join(root, name, file.Name())
+			if input, err := LoadImage(path, options); err != nil {
+				return nil, err
+			} else {
+				folder.Samples = append(folder.Samples, &Sample{
+					Path:   path,
+					Label:  label,
+					Input:  input,
+					Target: OneHot(len(folder.Labels), label, options.TargetLow, options.TargetHigh),
+				})
+			}
+		}
+	}
+	return folder, nil
+}
+
+func (folder *ImageFolder) InputSize() int {
+	return folder.Options.InputSize()
+}
+
+func (folder *ImageFolder) OutputSize() int {
+	return len(folder.Labels)
+}
+
+func OneHot(size, index int, low, high float64) *mat.Dense {
+	targets := make([]float64, size)
+	for i := range targets {
+		targets[i] = low
+	}
+	targets[index] = high
+	return mat.NewDense(size, 1, targets)
+}
+
+// Decodes a single image (png, jpeg or gif) and converts it
+//   into an input column according to the options. This is
+//   intended for both loading the folder and predicting.
+func LoadImage(filename string, options ImageFolderOptions) (*mat.Dense, error) {
+	var err error
+	if options, err = options.normalized(); err != nil {
+		return nil, err
+	}
+
+	var file *os.File
+	if file, err = os.Open(filename); err != nil {
+		return nil, err
+	} else {
+		defer file.Close()
+	}
+
+	var img image.Image
+	if img, _, err = image.Decode(file); err != nil {
+		return nil, errors.New(fmt.Sprintf("could not decode %v: %v", filename, err))
+	}
+	if img.Bounds().Empty() {
+		return nil, errors.New(fmt.Sprintf("%v is an empty image", filename))
+	}
+	return ImageInput(img, options), nil
+}
+
+// Converts, resizes and normalizes an already decoded image.
+// Options are expected to be already valid, and the image not
+//   empty.
+func ImageInput(img image.Image, options ImageFolderOptions) *mat.Dense {
+	if img.Bounds().Empty() {
+		panic("the image is empty")
+	}
+	if options.Low == 0 && options.High == 0 {
+		options.Low, options.High = 0.01, 1.0
+	}
+	channels := options.Mode.Channels()
+	pixels := resize(toChannels(img, options.Mode), img.Bounds().Dx(), img.Bounds().Dy(), channels, options.Width, options.Height)
+	for i, x := range pixels {
+		pixels[i] = options.Low + x/255.0*(options.High-options.Low)
+	}
+	return mat.NewDense(len(pixels), 1, pixels)
+}
+
+// Returns the pixels in row-major order, channels interleaved,
+//   in the [0, 255] range.
+func toChannels(img image.Image, mode ColorMode) []float64 {
+	bounds := img.Bounds()
+	channels := mode.Channels()
+	pixels := make([]float64, bounds.Dx()*bounds.Dy()*channels)
+	index := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if mode == RGB {
+				r, g, b, _ := img.At(x, y).RGBA()
+				pixels[index] = float64(r >> 8)
+				pixels[index+1] = float64(g >> 8)
+				pixels[index+2] = float64(b >> 8)
+			} else {
+				pixels[index] = float64(color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+			}
+			index += channels
+		}
+	}
+	return pixels
+}
+
+// Bilinear resize of interleaved pixels
+func resize(pixels []float64, width, height, channels, newWidth, newHeight int) []float64 {
+	if width == newWidth && height == newHeight {
+		return pixels
+	}
+	result := make([]float64, newWidth*newHeight*channels)
+	xRatio := float64(width) / float64(newWidth)
+	yRatio := float64(height) / float64(newHeight)
+	at := func(x, y, c int) float64 {
+		return pixels[(y*width+x)*channels+c]
+	}
+	for y := 0; y < newHeight; y++ {
+		// Sampling at the center of the target pixel
+		sy := (float64(y)+0.5)*yRatio - 0.5
+		y0, y1, fy := neighbours(sy, height)
+		for x := 0; x < newWidth; x++ {
+			sx := (float64(x)+0.5)*xRatio - 0.5
+			x0, x1, fx := neighbours(sx, width)
+			for c := 0; c < channels; c++ {
+				top := at(x0, y0, c)*(1-fx) + at(x1, y0, c)*fx
+				bottom := at(x0, y1, c)*(1-fx) + at(x1, y1, c)*fx
+				result[(y*newWidth+x)*channels+c] = top*(1-fy) + bottom*fy
+			}
+		}
+	}
+	return result
+}
+
+func neighbours(position float64, size int) (int, int, float64) {
+	if position < 0 {
+		position = 0
+	}
+	low := int(position)
+	if low >= size-1 {
+		return size - 1, size - 1, 0
+	}
+	return low, low + 1, position - float64(low)
+}
+
+type serializedLabels struct {
+	Labels []string
+}
+
+// Saves the label index mapping, so the outputs of a network
+//   trained on this folder can be mapped back to label names.
+func SaveLabels(labels []string, filename string) error {
+	var file *os.File
+	var err error
+	if file, err = os.Create(filename); err != nil {
+		return err
+	} else {
+		defer file.Close()
+	}
+	return json.NewEncoder(file).Encode(&serializedLabels{Labels: labels})
+}
+
+func LoadLabels(filename string) ([]string, error) {
+	var file *os.File
+	var err error
+	if file, err = os.Open(filename); err != nil {
+		return nil, err
+	} else {
+		defer file.Close()
+	}
+
+	var serialized serializedLabels
+	if err := json.NewDecoder(file).Decode(&serialized); err != nil {
+		return nil, err
+	}
+	if len(serialized.Labels) == 0 {
+		return nil, errors.New("the labels file has no labels")
+	}
+	return serialized.Labels, nil
+}
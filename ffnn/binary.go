@@ -0,0 +1,300 @@
+package ffnn
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The binary container is laid out as (all little-endian):
+//
+//   magic       4 bytes, "FFNB"
+//   version     uint16, the container version
+//   flags       uint16, reserved (0)
+//   metaLength  uint32
+//   meta        the network document as JSON, with its tensors
+//               referencing the table instead of holding data
+//   count       uint32, the number of tensors
+//   table       count entries of:
+//                 dtype   uint8 (1: float64, 2: float32)
+//                 rows    uint32
+//                 columns uint32
+//                 offset  uint64, from the start of the payloads
+//                 length  uint64, in bytes
+//   payloads    the raw row-major elements of each tensor
+var binaryMagic = []byte("FFNB")
+
+const binaryContainerVersion = 1
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+var dtypeCodes = map[string]uint8{
+	Float64: 1,
+	Float32: 2,
+}
+
+// Limits on the sizes read from the headers, which are checked
+//   before allocating anything
+const (
+	maxBinaryMetaLength = 256 << 20
+	maxTensorBytes      = 1 << 40
+)
+
+// Buffers up to this size are allocated at once; larger ones grow
+//   as their bytes arrive, so corrupt lengths fail as truncated
+const preallocatedBytes = 16 << 20
+
+type Format int
+
+const (
+	// The JSON document, with tensors as base64 data
+	JSONFormat Format = iota
+	// The binary container
+	BinaryFormat
+)
+
+type Precision int
+
+const (
+	DoublePrecision Precision = iota
+	SinglePrecision
+)
+
+type Compression int
+
+const (
+	NoCompression Compression = iota
+	GzipCompression
+)
+
+// How to save a network. The zero value is the plain JSON
+//   format with float64 tensors. Load detects all the formats.
+type SaveOptions struct {
+	Format      Format
+	Precision   Precision
+	Compression Compression
+	// When present, the network is signed (HMAC-SHA256) with it
+	SigningKey []byte
+}
+
+// All the tensors in the document, in a fixed order
+func (serialized *serializedFFNetwork) tensors() []*serializedTensor {
+	tensors := make([]*serializedTensor, 0, len(serialized.Layers) * 2)
+	for _, layer := range serialized.Layers {
+		tensors = append(tensors, layer.W, layer.B)
+		tensors = append(tensors, layer.P...)
+	}
+	return tensors
+}
+
+func encodeBinary(serialized *serializedFFNetwork, writer io.Writer, key []byte) error {
+	tensors := serialized.tensors()
+	payloads := make([][]byte, len(tensors))
+	for index, tensor := range tensors {
+		ref := index
+		payloads[index], tensor.Data, tensor.Ref = tensor.Data, nil, &ref
+	}
+	err := seal(serialized, payloads, key)
+	var meta []byte
+	if err == nil {
+		meta, err = json.Marshal(serialized)
+	}
+	// Giving the data back, since the document is not ours
+	for index, tensor := range tensors {
+		tensor.Data, tensor.Ref = payloads[index], nil
+	}
+	if err != nil {
+		return err
+	}
+
+	buffer := &bytes.Buffer{}
+	buffer.Write(binaryMagic)
+	binary.Write(buffer, binary.LittleEndian, uint16(binaryContainerVersion))
+	binary.Write(buffer, binary.LittleEndian, uint16(0))
+	binary.Write(buffer, binary.LittleEndian, uint32(len(meta)))
+	buffer.Write(meta)
+	binary.Write(buffer, binary.LittleEndian, uint32(len(tensors)))
+	offset := uint64(0)
+	for index, tensor := range tensors {
+		binary.Write(buffer, binary.LittleEndian, dtypeCodes[tensor.DType])
+		binary.Write(buffer, binary.LittleEndian, uint32(tensor.Shape[0]))
+		binary.Write(buffer, binary.LittleEndian, uint32(tensor.Shape[1]))
+		binary.Write(buffer, binary.LittleEndian, offset)
+		binary.Write(buffer, binary.LittleEndian, uint64(len(payloads[index])))
+		offset += uint64(len(payloads[index]))
+	}
+	if _, err := writer.Write(buffer.Bytes()); err != nil {
+		return err
+	}
+	for _, payload := range payloads {
+		if _, err := writer.Write(payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type binaryTableEntry struct {
+	DType   uint8
+	Rows    uint32
+	Columns uint32
+	Offset  uint64
+	Length  uint64
+}
+
+// Decodes the container (after its magic), returning the
+//   verified and migrated document with its tensors' data in place
+func decodeBinary(reader io.Reader, options LoadOptions) (*serializedFFNetwork, error) {
+	var header struct {
+		Version    uint16
+		Flags      uint16
+		MetaLength uint32
+	}
+	if err := binary.Read(reader, binary.LittleEndian, &header); err != nil {
+		return nil, truncated(err)
+	}
+	if header.Version > binaryContainerVersion {
+		return nil, fmt.Errorf(
+			"%w (binary container version: %v, supported up to: %v)", ErrNewerFormat, header.Version, binaryContainerVersion,
+		)
+	}
+
+	if header.MetaLength > maxBinaryMetaLength {
+		return nil, errors.New(fmt.Sprintf("binary container meta of %v bytes is too large", header.MetaLength))
+	}
+	meta, err := readBytes(reader, uint64(header.MetaLength))
+	if err != nil {
+		return nil, err
+	}
+	// The document is verified as written, and migrated apart, so
+	//   the count of tensors can be checked before reading them
+	var document, migrated map[string]json.RawMessage
+	if err := json.Unmarshal(meta, &document); err != nil {
+		return nil, err
+	}
+	json.Unmarshal(meta, &migrated)
+	serialized, err := migrateDocument(migrated)
+	if err != nil {
+		return nil, err
+	}
+	tensors := serialized.tensors()
+
+	var count uint32
+	if err := binary.Read(reader, binary.LittleEndian, &count); err != nil {
+		return nil, truncated(err)
+	}
+	if int(count) != len(tensors) {
+		return nil, errors.New(fmt.Sprintf("binary container has %v tensors, but the network needs %v", count, len(tensors)))
+	}
+	table := make([]binaryTableEntry, count)
+	if err := binary.Read(reader, binary.LittleEndian, table); err != nil {
+		return nil, truncated(err)
+	}
+
+	// Payloads are read in order, so they must be contiguous
+	payloads := make([][]byte, count)
+	offset := uint64(0)
+	for index, entry := range table {
+		if entry.Offset != offset {
+			return nil, errors.New(fmt.Sprintf("binary container tensor %v is not contiguous", index))
+		}
+		if err := entry.check(); err != nil {
+			return nil, errors.New(fmt.Sprintf("binary container tensor %v %v", index, err))
+		}
+		if payloads[index], err = readBytes(reader, entry.Length); err != nil {
+			return nil, err
+		}
+		offset += entry.Length
+	}
+
+	if err := verify(document, payloads, options); err != nil {
+		return nil, err
+	}
+	for index, tensor := range tensors {
+		if tensor == nil || tensor.Ref == nil || *tensor.Ref < 0 || *tensor.Ref >= len(table) {
+			return nil, errors.New(fmt.Sprintf("binary container tensor %v has an invalid reference", index))
+		}
+		entry := table[*tensor.Ref]
+		if code, found := dtypeCodes[tensor.DType]; !found || code != entry.DType ||
+			len(tensor.Shape) != 2 || tensor.Shape[0] != int(entry.Rows) || tensor.Shape[1] != int(entry.Columns) {
+			return nil, errors.New(fmt.Sprintf("binary container tensor %v does not match its table entry", index))
+		}
+		tensor.Data, tensor.Ref = payloads[*tensor.Ref], nil
+	}
+	return serialized, nil
+}
+
+// Whether the dtype is known and the length is the one of its shape
+func (entry binaryTableEntry) check() error {
+	size := uint64(0)
+	for dtype, code := range dtypeCodes {
+		if code == entry.DType {
+			size = uint64(dtypeSize(dtype))
+		}
+	}
+	if size == 0 {
+		return errors.New(fmt.Sprintf("has unknown dtype %v", entry.DType))
+	}
+	// Both are uint32, so their product cannot overflow
+	if elements := uint64(entry.Rows) * uint64(entry.Columns); elements > maxTensorBytes / size {
+		return errors.New(fmt.Sprintf("is too large (%vx%v)", entry.Rows, entry.Columns))
+	} else if entry.Length != elements * size {
+		return errors.New(fmt.Sprintf("length %v does not match its shape", entry.Length))
+	}
+	return nil
+}
+
+// Reads exactly length bytes (see preallocatedBytes)
+func readBytes(reader io.Reader, length uint64) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	if length <= preallocatedBytes {
+		buffer.Grow(int(length))
+	}
+	if _, err := io.CopyN(buffer, reader, int64(length)); err != nil {
+		return nil, truncated(err)
+	}
+	return buffer.Bytes(), nil
+}
+
+func truncated(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errors.New("binary container is truncated")
+	}
+	return err
+}
+
+// Wraps the writer according to the compression. The returned
+//   closer must be closed (before the writer) to flush.
+func compress(writer io.Writer, compression Compression) (io.Writer, io.Closer, error) {
+	switch compression {
+	case NoCompression:
+		return writer, io.NopCloser(nil), nil
+	case GzipCompression:
+		gzipWriter := gzip.NewWriter(writer)
+		return gzipWriter, gzipWriter, nil
+	}
+	return nil, nil, errors.New(fmt.Sprintf("unknown compression: %v", compression))
+}
+
+// Unwraps the compression (if any) and tells whether the stream
+//   is a binary container (already consumed its magic) or JSON
+func detect(reader io.Reader) (io.Reader, bool, error) {
+	buffered := bufio.NewReader(reader)
+	if magic, err := buffered.Peek(len(gzipMagic)); err == nil && bytes.Equal(magic, gzipMagic) {
+		gzipReader, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, false, err
+		}
+		buffered = bufio.NewReader(gzipReader)
+	}
+	if magic, err := buffered.Peek(len(binaryMagic)); err == nil && bytes.Equal(magic, binaryMagic) {
+		buffered.Discard(len(binaryMagic))
+		return buffered, true, nil
+	}
+	return buffered, false, nil
+}
@@ -0,0 +1,313 @@
+package ffnn
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The version of the checkpoint files written by this package
+const CheckpointVersion = 1
+
+// A snapshot of a training in progress: the network (with its
+//   optimizer moments and step count) and the state of the
+//   training loop, so it can be resumed exactly where it stopped.
+type Checkpoint struct {
+	Network *FFNetwork
+	// Completed epochs, and samples already trained in the
+	//   current epoch
+	Epoch  int
+	Sample int
+	// The base learning rate, and its schedule (nil for a
+	//   constant rate), whose position is the epoch
+	LearningRate float64
+	Schedule     Schedule
+	// The state of the training's random source, if any (see
+	//   random.Source)
+	RandomState uint64
+	// The best validation metric so far (nil when not tracked),
+	//   and the number of epochs completed when it was reached
+	BestMetric *float64
+	BestEpoch  int
+}
+
+type serializedCheckpoint struct {
+	Version int
+	// The network document, as saved by Encode
+	Network      json.RawMessage
+	Moments      [][][]*serializedTensor
+	Step         int
+	Epoch        int
+	Sample       int
+	LearningRate float64
+	Schedule     *serializedSchedule `json:",omitempty"`
+	RandomState  uint64              `json:",omitempty"`
+	BestMetric   *float64            `json:",omitempty"`
+	BestEpoch    int                 `json:",omitempty"`
+}
+
+// Saves the checkpoint (gzipped) atomically
+func SaveCheckpoint(checkpoint *Checkpoint, filename string) error {
+	filename = withExtension(filename, "ckpt")
+	if filename == "" {
+		return errors.New("filename is empty")
+	}
+	return writeAtomically(filename, func(writer io.Writer) error {
+		return EncodeCheckpoint(checkpoint, writer)
+	})
+}
+
+func EncodeCheckpoint(checkpoint *Checkpoint, writer io.Writer) error {
+	if checkpoint == nil || checkpoint.Network == nil {
+		return errors.New("checkpoint (or its network) is nil")
+	}
+	network := checkpoint.Network
+	document, err := serialize(network)
+	if err == nil {
+		err = seal(document, nil, nil)
+	}
+	if err != nil {
+		return err
+	}
+	serialized := &serializedCheckpoint{
+		Version:      CheckpointVersion,
+		Moments:      make([][][]*serializedTensor, len(network.layers)),
+		Step:         network.step,
+		Epoch:        checkpoint.Epoch,
+		Sample:       checkpoint.Sample,
+		LearningRate: checkpoint.LearningRate,
+		RandomState:  checkpoint.RandomState,
+		BestMetric:   checkpoint.BestMetric,
+		BestEpoch:    checkpoint.BestEpoch,
+	}
+	if serialized.Network, err = json.Marshal(document); err != nil {
+		return err
+	}
+	if serialized.Schedule, err = encodeSchedule(checkpoint.Schedule); err != nil {
+		return err
+	}
+	// Layers not trained yet have no moments
+	for index := range network.layers {
+		if network.moments == nil || network.moments[index] == nil {
+			continue
+		}
+		for _, parameterMoments := range network.moments[index] {
+			tensors := make([]*serializedTensor, len(parameterMoments))
+			for m, moment := range parameterMoments {
+				tensors[m] = encodeTensor(moment)
+			}
+			serialized.Moments[index] = append(serialized.Moments[index], tensors)
+		}
+	}
+
+	compressed, closer, err := compress(writer, GzipCompression)
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(compressed).Encode(serialized)
+	if closeErr := closer.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func LoadCheckpoint(filename string) (*Checkpoint, error) {
+	filename = withExtension(filename, "ckpt")
+	if filename == "" {
+		return nil, errors.New("filename is empty")
+	}
+
+	// Open file for reading
+	var file *os.File
+	var err error
+	if file, err = os.Open(filename); err != nil {
+		return nil, err
+	} else {
+		defer file.Close()
+	}
+
+	if checkpoint, err := DecodeCheckpoint(file); err != nil {
+		return nil, fmt.Errorf("%v: %w", filename, err)
+	} else {
+		return checkpoint, nil
+	}
+}
+
+func DecodeCheckpoint(reader io.Reader) (*Checkpoint, error) {
+	reader, isBinary, err := detect(reader)
+	if err != nil {
+		return nil, err
+	} else if isBinary {
+		return nil, errors.New("not a checkpoint, but a network")
+	}
+	var serialized serializedCheckpoint
+	if err := json.NewDecoder(reader).Decode(&serialized); err != nil {
+		return nil, err
+	}
+	if serialized.Version > CheckpointVersion {
+		return nil, fmt.Errorf(
+			"%w (checkpoint version: %v, supported up to: %v)", ErrNewerFormat, serialized.Version, CheckpointVersion,
+		)
+	}
+	if len(serialized.Network) == 0 {
+		return nil, errors.New("the checkpoint has no network")
+	}
+
+	// The network was always saved with its checksum
+	document, err := decodeDocument(bytes.NewReader(serialized.Network), LoadOptions{RequireChecksum: true})
+	if err != nil {
+		return nil, err
+	}
+	network, err := deserialize(document)
+	if err != nil {
+		return nil, err
+	}
+	if err := restoreMoments(network, serialized.Moments); err != nil {
+		return nil, err
+	}
+	network.step = serialized.Step
+
+	schedule, err := decodeSchedule(serialized.Schedule)
+	if err != nil {
+		return nil, err
+	}
+	return &Checkpoint{
+		Network:      network,
+		Epoch:        serialized.Epoch,
+		Sample:       serialized.Sample,
+		LearningRate: serialized.LearningRate,
+		Schedule:     schedule,
+		RandomState:  serialized.RandomState,
+		BestMetric:   serialized.BestMetric,
+		BestEpoch:    serialized.BestEpoch,
+	}, nil
+}
+
+// Restores the moments into the (zero) ones the network creates,
+//   which tells their expected count and shapes
+func restoreMoments(network *FFNetwork, moments [][][]*serializedTensor) error {
+	if len(moments) != len(network.layers) {
+		return errors.New(fmt.Sprintf("the checkpoint has moments for %v layers, not %v", len(moments), len(network.layers)))
+	}
+	for index, layerMoments := range moments {
+		if layerMoments == nil {
+			continue
+		}
+		expected := network.layerMoments(index)
+		if len(layerMoments) != len(expected) {
+			return errors.New(fmt.Sprintf("layer %v: expected moments for %v parameters, got %v", index, len(expected), len(layerMoments)))
+		}
+		for p, parameterMoments := range layerMoments {
+			if len(parameterMoments) != len(expected[p]) {
+				return errors.New(fmt.Sprintf(
+					"layer %v: the optimizer needs %v moments per parameter, got %v", index, len(expected[p]), len(parameterMoments),
+				))
+			}
+			for m, tensor := range parameterMoments {
+				rows, columns := expected[p][m].Dims()
+				if moment, err := decodeTensor(tensor, rows, columns, fmt.Sprintf("layer %v moment", index)); err != nil {
+					return err
+				} else {
+					expected[p][m].Copy(moment)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+
+// Saves numbered checkpoints into a directory, keeping only the
+//   last Keep ones (or all of them, when Keep < 1)
+type CheckpointRotation struct {
+	Directory string
+	Keep      int
+}
+
+const checkpointPrefix = "checkpoint-"
+
+// Saves the checkpoint, named after the network's step count, and
+//   removes the older ones beyond Keep. Since the step count is not
+//   saved with the networks, and restarts with new optimizers, the
+//   number is raised past the newest checkpoint's when needed, so
+//   the new one always sorts last. Returns the file name.
+func (rotation *CheckpointRotation) Save(checkpoint *Checkpoint) (string, error) {
+	if err := os.MkdirAll(rotation.Directory, 0755); err != nil {
+		return "", err
+	}
+	filenames, err := rotation.List()
+	if err != nil {
+		return "", err
+	}
+	number := int64(checkpoint.Network.step)
+	if len(filenames) > 0 {
+		if last, _ := checkpointNumber(filenames[len(filenames) - 1]); last >= number {
+			number = last + 1
+		}
+	}
+	filename := filepath.Join(rotation.Directory, fmt.Sprintf("%v%012d.ckpt", checkpointPrefix, number))
+	if err := SaveCheckpoint(checkpoint, filename); err != nil {
+		return "", err
+	}
+	if rotation.Keep < 1 {
+		return filename, nil
+	}
+	// The new one is not listed yet, and is kept
+	for len(filenames) > rotation.Keep - 1 {
+		if err := os.Remove(filenames[0]); err != nil {
+			return "", err
+		}
+		filenames = filenames[1:]
+	}
+	return filename, nil
+}
+
+// The number in a checkpoint file name, if it is one
+func checkpointNumber(filename string) (int64, bool) {
+	name := filepath.Base(filename)
+	if !strings.HasPrefix(name, checkpointPrefix) || !strings.HasSuffix(name, ".ckpt") {
+		return 0, false
+	}
+	number, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(name, checkpointPrefix), ".ckpt"), 10, 64)
+	return number, err == nil && number >= 0
+}
+
+// The checkpoints in the directory, from the oldest to the newest
+func (rotation *CheckpointRotation) List() ([]string, error) {
+	entries, err := os.ReadDir(rotation.Directory)
+	if err != nil {
+		return nil, err
+	}
+	var filenames []string
+	numbers := map[string]int64{}
+	for _, entry := range entries {
+		if number, ok := checkpointNumber(entry.Name()); ok && !entry.IsDir() {
+			filename := filepath.Join(rotation.Directory, entry.Name())
+			filenames = append(filenames, filename)
+			numbers[filename] = number
+		}
+	}
+	sort.Slice(filenames, func(i, j int) bool {
+		return numbers[filenames[i]] < numbers[filenames[j]]
+	})
+	return filenames, nil
+}
+
+// The newest checkpoint in the directory
+func (rotation *CheckpointRotation) Latest() (string, error) {
+	filenames, err := rotation.List()
+	if err != nil {
+		return "", err
+	}
+	if len(filenames) == 0 {
+		return "", fmt.Errorf("no checkpoints in %v: %w", rotation.Directory, os.ErrNotExist)
+	}
+	return filenames[len(filenames) - 1], nil
+}
@@ -0,0 +1,244 @@
+package ffnn
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// A declarative description of a network, intended to be
+//   written as JSON, e.g.:
+//
+//   {
+//     "input_size": 784,
+//     "learning_rate": 0.01,
+//     "loss": "HalfSquaredError",
+//     "optimizer": {"name": "Adam", "beta1": 0.9},
+//     "regularization": {"l2": 0.0001},
+//     "layers": [
+//       {"size": 200, "activator": "LeakyReLU", "activator_params": {"alpha": 0.2}, "initializer": "He"},
+//       {"size": 10, "activator": "Sigmoid"}
+//     ]
+//   }
+//
+// Omitted names (loss, optimizer, activators, initializers) take
+//   their default values. Parameterized losses and activators
+//   (see Configurable) take their parameters from loss_params and
+//   activator_params.
+type Config struct {
+	InputSize      int                   `json:"input_size"`
+	LearningRate   float64               `json:"learning_rate"`
+	Loss           string                `json:"loss,omitempty"`
+	LossParams     json.RawMessage       `json:"loss_params,omitempty"`
+	Optimizer      OptimizerConfig       `json:"optimizer,omitempty"`
+	Regularization *RegularizationConfig `json:"regularization,omitempty"`
+	Layers         []*LayerConfig        `json:"layers"`
+}
+
+type LayerConfig struct {
+	Size            int             `json:"size"`
+	Activator       string          `json:"activator,omitempty"`
+	ActivatorParams json.RawMessage `json:"activator_params,omitempty"`
+	Initializer     string          `json:"initializer,omitempty"`
+}
+
+type RegularizationConfig struct {
+	L1 float64 `json:"l1,omitempty"`
+	L2 float64 `json:"l2,omitempty"`
+}
+
+// The optimizer "name", and its parameters as the remaining
+//   keys (matched case-insensitively against its fields).
+type OptimizerConfig map[string]json.RawMessage
+
+// All the problems found while validating a config, one per line
+type ConfigError struct {
+	Problems []string
+}
+
+func (err *ConfigError) Error() string {
+	return "invalid network config:\n  " + strings.Join(err.Problems, "\n  ")
+}
+
+func (err *ConfigError) add(path string, format string, args ...interface{}) {
+	err.Problems = append(err.Problems, path+": "+fmt.Sprintf(format, args...))
+}
+
+func LoadConfig(filename string) (*Config, error) {
+	var file *os.File
+	var err error
+	if file, err = os.Open(filename); err != nil {
+		return nil, err
+	} else {
+		defer file.Close()
+	}
+
+	if config, err := ParseConfig(file); err != nil {
+		return nil, fmt.Errorf("%v: %w", filename, err)
+	} else {
+		return config, nil
+	}
+}
+
+// Decodes and validates a config. Unknown keys are errors, so
+//   typos don't go unnoticed.
+func ParseConfig(reader io.Reader) (*Config, error) {
+	var config Config
+	decoder := json.NewDecoder(reader)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return nil, describeDecodeError(err)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func describeDecodeError(err error) error {
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		return fmt.Errorf("malformed JSON at byte %v: %v", e.Offset, e)
+	case *json.UnmarshalTypeError:
+		return fmt.Errorf("%v: expected a value of type %v, got %v", e.Field, e.Type, e.Value)
+	}
+	return err
+}
+
+// Checks the whole config, reporting all the problems at once
+func (config *Config) Validate() error {
+	problems := &ConfigError{}
+	if config.InputSize < 1 {
+		problems.add("input_size", "must be >= 1, got %v", config.InputSize)
+	}
+	if config.LearningRate <= 0 {
+		problems.add("learning_rate", "must be positive (and, preferably, small), got %v", config.LearningRate)
+	}
+	if _, err := config.lossFunction(); err != nil {
+		problems.add("loss", "%v", err)
+	}
+	if _, err := config.Optimizer.build(); err != nil {
+		problems.add("optimizer", "%v", err)
+	}
+	if regularization := config.Regularization; regularization != nil {
+		if regularization.L1 < 0 {
+			problems.add("regularization.l1", "must not be negative, got %v", regularization.L1)
+		}
+		if regularization.L2 < 0 {
+			problems.add("regularization.l2", "must not be negative, got %v", regularization.L2)
+		}
+	}
+	if len(config.Layers) == 0 {
+		problems.add("layers", "at least one layer must be present")
+	}
+	for index, layer := range config.Layers {
+		path := fmt.Sprintf("layers[%v]", index)
+		if layer == nil {
+			problems.add(path, "must be an object")
+			continue
+		}
+		if layer.Size < 1 {
+			problems.add(path+".size", "must be >= 1, got %v", layer.Size)
+		}
+		if _, err := layer.activator(); err != nil {
+			problems.add(path+".activator", "%v", err)
+		}
+		if layer.Initializer != "" {
+			if _, err := LookupInitializer(layer.Initializer); err != nil {
+				problems.add(path+".initializer", "%v", err)
+			}
+		}
+	}
+	if len(problems.Problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// Empty names give the defaults, which the strict lookups don't
+func (config *Config) lossFunction() (ErrorMetric, error) {
+	if config.Loss == "" {
+		errorMetric := GetErrorMetric("")
+		if err := decodeConfig(errorMetric, errorMetric.Name(), config.LossParams); err != nil {
+			return nil, err
+		}
+		return errorMetric, nil
+	}
+	return decodeErrorMetric(config.Loss, config.LossParams)
+}
+
+func (config *LayerConfig) activator() (Activator, error) {
+	if config.Activator == "" {
+		activator := GetActivator("")
+		if err := decodeConfig(activator, activator.Name(), config.ActivatorParams); err != nil {
+			return nil, err
+		}
+		return activator, nil
+	}
+	return decodeActivator(config.Activator, config.ActivatorParams)
+}
+
+// Creates the configured optimizer: its defaults, overridden by
+//   the given parameters
+func (config OptimizerConfig) build() (Optimizer, error) {
+	name := ""
+	params := map[string]json.RawMessage{}
+	for key, value := range config {
+		if key == "name" {
+			if err := json.Unmarshal(value, &name); err != nil {
+				return nil, fmt.Errorf("name must be a string")
+			}
+		} else {
+			params[key] = value
+		}
+	}
+
+	optimizer := defaultOptimizer()
+	if name != "" {
+		var err error
+		if optimizer, err = NewOptimizer(name); err != nil {
+			return nil, err
+		}
+	}
+	if len(params) > 0 {
+		data, _ := json.Marshal(params)
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(optimizer); err != nil {
+			return nil, fmt.Errorf("invalid parameters for %v: %v", optimizer.Name(), describeDecodeError(err))
+		}
+	}
+	if err := validateOptimizer(optimizer); err != nil {
+		return nil, err
+	}
+	return optimizer, nil
+}
+
+// Creates a builder from a config, with all the layers already
+//   added. Further settings (e.g. preprocessing) can be added to
+//   the builder before building the network.
+func NewFromConfig(config *Config) (*FFNetworkBuilder, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	errorMetric, _ := config.lossFunction()
+	builder := New(config.LearningRate, config.InputSize, errorMetric)
+	optimizer, _ := config.Optimizer.build()
+	builder.WithOptimizer(optimizer)
+	if config.Regularization != nil {
+		builder.WithRegularization(config.Regularization.L1, config.Regularization.L2)
+	}
+	for _, layer := range config.Layers {
+		activator, _ := layer.activator()
+		var initializer Initializer
+		if layer.Initializer != "" {
+			initializer, _ = LookupInitializer(layer.Initializer)
+		}
+		builder.AddInitializedLayer(layer.Size, activator, initializer)
+	}
+	return builder, nil
+}
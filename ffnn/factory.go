@@ -2,8 +2,12 @@ package ffnn
 
 import (
 	"os"
+	"io"
+	"io/fs"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"math/rand"
 	"strings"
 	"gonum.org/v1/gonum/mat"
 )
@@ -11,15 +15,27 @@ import (
 
 type serializedFFLayer struct {
 	F          string
+	FConfig    json.RawMessage `json:",omitempty"`
 	OutputSize int
-	W          []byte
-	B          []byte
+	W          *serializedTensor
+	B          *serializedTensor
+	P          []*serializedTensor `json:",omitempty"`
 }
 type serializedFFNetwork struct {
+	Version             int
 	C                   string
+	CConfig             json.RawMessage          `json:",omitempty"`
 	DefaultLearningRate float64
 	InputSize           int
 	Layers              []*serializedFFLayer
+	Preprocessing       *serializedPreprocessing `json:",omitempty"`
+	TargetScaling       *serializedTargetScaling `json:",omitempty"`
+	Optimizer           *serializedOptimizer     `json:",omitempty"`
+	L1                  float64                  `json:",omitempty"`
+	L2                  float64                  `json:",omitempty"`
+	Metadata            *Metadata                `json:",omitempty"`
+	Checksum            string                   `json:",omitempty"`
+	Signature           string                   `json:",omitempty"`
 }
 func withExtension(filename string, extension string) string {
 	if strings.Trim(filename, " \r\n\t") == "" {
@@ -31,13 +47,18 @@ func withExtension(filename string, extension string) string {
 	}
 	return filename
 }
-func loadLayer(inputSize int, outputSize int, activator Activator, wMarshaled, bMarshalled []byte) (*FFLayer, error) {
+func loadLayer(inputSize int, outputSize int, activator Activator, w, b *serializedTensor, p []*serializedTensor) (*FFLayer, error) {
 	// Read everything
-	return decodeFFLayer(inputSize, outputSize, activator, wMarshaled, bMarshalled)
+	return decodeFFLayer(inputSize, outputSize, activator, w, b, p)
 }
 
 
 func Load(filename string) (*FFNetwork, error) {
+	return LoadWith(filename, LoadOptions{})
+}
+
+
+func LoadWith(filename string, options LoadOptions) (*FFNetwork, error) {
 	filename = withExtension(filename, "ffnn")
 	if filename == "" {
 		return nil, errors.New("filename is empty")
@@ -52,12 +73,93 @@ func Load(filename string) (*FFNetwork, error) {
 		defer file.Close()
 	}
 
+	return DecodeWith(file, options)
+}
+
+
+// Like Load, but reading from a file system (e.g. an embed.FS)
+func LoadFS(fsys fs.FS, name string) (*FFNetwork, error) {
+	return LoadFSWith(fsys, name, LoadOptions{})
+}
+
+
+func LoadFSWith(fsys fs.FS, name string, options LoadOptions) (*FFNetwork, error) {
+	name = withExtension(name, "ffnn")
+	if name == "" {
+		return nil, errors.New("filename is empty")
+	}
+
+	// Open file for reading
+	var file fs.File
+	var err error
+	if file, err = fsys.Open(name); err != nil {
+		return nil, err
+	} else {
+		defer file.Close()
+	}
+
+	return DecodeWith(file, options)
+}
+
+
+// Reads a network from a stream in any of the formats (see
+//   SaveOptions), detecting it.
+func Decode(reader io.Reader) (*FFNetwork, error) {
+	return DecodeWith(reader, LoadOptions{})
+}
+
+
+func DecodeWith(reader io.Reader, options LoadOptions) (*FFNetwork, error) {
+	var serialized *serializedFFNetwork
+	if reader, isBinary, err := detect(reader); err != nil {
+		return nil, err
+	} else if isBinary {
+		serialized, err = decodeBinary(reader, options)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		serialized, err = decodeDocument(reader, options)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return deserialize(serialized)
+}
+
+
+func decodeDocument(reader io.Reader, options LoadOptions) (*serializedFFNetwork, error) {
+	// Decoding generically first, so it can be verified as written
+	//   and older versions can be migrated
+	var document map[string]json.RawMessage
+	decoder := json.NewDecoder(reader)
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+	if err := verify(document, nil, options); err != nil {
+		return nil, err
+	}
+	return migrateDocument(document)
+}
+
+
+func migrateDocument(document map[string]json.RawMessage) (*serializedFFNetwork, error) {
+	if err := migrate(document); err != nil {
+		return nil, err
+	}
+
 	var serialized serializedFFNetwork
-	decoder := json.NewDecoder(file)
-	if err := decoder.Decode(&serialized); err != nil {
+	if data, err := json.Marshal(document); err != nil {
+		return nil, err
+	} else if err := json.Unmarshal(data, &serialized); err != nil {
 		return nil, err
 	}
+	return &serialized, nil
+}
 
+
+func deserialize(serialized *serializedFFNetwork) (*FFNetwork, error) {
 	if serialized.InputSize < 1 {
 		return nil, errors.New("input size must be >= 1")
 	}
@@ -71,13 +173,31 @@ func Load(filename string) (*FFNetwork, error) {
 		return nil, errors.New("learning rate must be positive (and, preferably, small)")
 	}
 
+	// Unknown names are errors: falling back to the defaults would
+	//   silently give wrong predictions
+	errorMetric, err := decodeErrorMetric(serialized.C, serialized.CConfig)
+	if err != nil {
+		return nil, err
+	}
+
 	network := &FFNetwork{
 		defaultLearningRate: serialized.DefaultLearningRate,
-		c:                   GetErrorMetric(serialized.C),
+		c:                   errorMetric,
 		layers:              make([]*FFLayer, layersCount),
 		rDaDz:               make([]*mat.Dense, layersCount),
 		rDcDa:               make([]*mat.Dense, layersCount),
 		delta:               make([]*mat.Dense, layersCount),
+		checksum:            serialized.Checksum,
+		metadata:            serialized.Metadata,
+	}
+
+	if optimizer, err := decodeOptimizer(serialized.Optimizer); err != nil {
+		return nil, err
+	} else {
+		network.SetOptimizer(optimizer)
+	}
+	if err := network.SetRegularization(serialized.L1, serialized.L2); err != nil {
+		return nil, err
 	}
 
 	inputSize := serialized.InputSize
@@ -86,9 +206,12 @@ func Load(filename string) (*FFNetwork, error) {
 		if outputSize < 1 {
 			return nil, errors.New("output size must be >= 1")
 		}
-		activator := GetActivator(serializedLayer.F)
+		activator, err := decodeActivator(serializedLayer.F, serializedLayer.FConfig)
+		if err != nil {
+			return nil, fmt.Errorf("layer %v: %w", index, err)
+		}
 
-		if layer, err := loadLayer(inputSize, outputSize, activator, serializedLayer.W, serializedLayer.B); err != nil {
+		if layer, err := loadLayer(inputSize, outputSize, activator, serializedLayer.W, serializedLayer.B, serializedLayer.P); err != nil {
 			return nil, err
 		} else {
 			network.layers[index] = layer
@@ -102,62 +225,198 @@ func Load(filename string) (*FFNetwork, error) {
 		inputSize = serializedLayer.OutputSize
 	}
 
+	if preprocessing, err := decodePreprocessing(serialized.Preprocessing); err != nil {
+		return nil, err
+	} else if err := network.SetPreprocessing(preprocessing); err != nil {
+		return nil, err
+	}
+
+	if scaling, err := decodeTargetScaling(serialized.TargetScaling); err != nil {
+		return nil, err
+	} else if err := network.SetTargetScaling(scaling); err != nil {
+		return nil, err
+	}
+
 	return network, nil
 }
 
 
 func Save(network *FFNetwork, filename string) (error) {
+	return SaveWith(network, filename, SaveOptions{})
+}
+
+
+// Saves the network into a temporary file first, which is then
+//   renamed into the given name, so a crash never leaves a
+//   truncated file behind.
+func SaveWith(network *FFNetwork, filename string, options SaveOptions) (error) {
 	filename = withExtension(filename, "ffnn")
+	if filename == "" {
+		return errors.New("filename is empty")
+	}
 	if network == nil {
 		return errors.New("network is nil")
 	}
 
-	// Open file for writing
+	return writeAtomically(filename, func(writer io.Writer) error {
+		return EncodeWith(network, writer, options)
+	})
+}
+
+
+// Writes into a temporary file first, in the same directory, which
+//   is then renamed: readers never see half-written files. The file
+//   keeps the mode of the one it replaces, or else gets the default
+//   one (0666 less the umask), like os.Create.
+func writeAtomically(filename string, write func(writer io.Writer) error) error {
 	var file *os.File
 	var err error
-	if file, err = os.Create(filename); err != nil {
+	if file, err = createTemporary(filename); err != nil {
 		return err
-	} else {
-		defer file.Close()
 	}
+	temporary := file.Name()
+	if info, statErr := os.Stat(filename); statErr == nil {
+		err = file.Chmod(info.Mode().Perm())
+	}
+	if err == nil {
+		err = write(file)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temporary, filename)
+	}
+	if err != nil {
+		os.Remove(temporary)
+	}
+	return err
+}
+
+// Creates a new file next to the given one. Unlike os.CreateTemp
+//   (always 0600), the umask decides its permissions.
+func createTemporary(filename string) (*os.File, error) {
+	for attempt := 0; ; attempt++ {
+		name := fmt.Sprintf("%v.tmp-%v", filename, rand.Uint32())
+		file, err := os.OpenFile(name, os.O_RDWR | os.O_CREATE | os.O_EXCL, 0666)
+		if errors.Is(err, fs.ErrExist) && attempt < 100 {
+			continue
+		}
+		return file, err
+	}
+}
+
+
+// Writes the network into a stream
+func Encode(network *FFNetwork, writer io.Writer) error {
+	return EncodeWith(network, writer, SaveOptions{})
+}
+
+
+func EncodeWith(network *FFNetwork, writer io.Writer, options SaveOptions) error {
+	if network == nil {
+		return errors.New("network is nil")
+	}
+
+	serialized, err := serialize(network)
+	if err != nil {
+		return err
+	}
+	switch options.Precision {
+	case DoublePrecision:
+	case SinglePrecision:
+		for _, tensor := range serialized.tensors() {
+			if err := tensor.convert(Float32); err != nil {
+				return err
+			}
+		}
+	default:
+		return errors.New(fmt.Sprintf("unknown precision: %v", options.Precision))
+	}
+
+	compressed, closer, err := compress(writer, options.Compression)
+	if err != nil {
+		return err
+	}
+	switch options.Format {
+	case JSONFormat:
+		if err = seal(serialized, nil, options.SigningKey); err == nil {
+			err = json.NewEncoder(compressed).Encode(serialized)
+		}
+	case BinaryFormat:
+		err = encodeBinary(serialized, compressed, options.SigningKey)
+	default:
+		err = errors.New(fmt.Sprintf("unknown format: %v", options.Format))
+	}
+	if closeErr := closer.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
 
-	serialized := serializedFFNetwork{
+func serialize(network *FFNetwork) (*serializedFFNetwork, error) {
+	var err error
+	serialized := &serializedFFNetwork{
+		Version:             FormatVersion,
 		DefaultLearningRate: network.defaultLearningRate,
 		InputSize:           network.layers[0].inputSize,
 		Layers:              make([]*serializedFFLayer, len(network.layers)),
 		C:                   network.c.Name(),
+		L1:                  network.l1,
+		L2:                  network.l2,
+		Metadata:            network.metadata,
+	}
+	if serialized.CConfig, err = encodeConfig(network.c); err != nil {
+		return nil, err
+	}
+	if serialized.Optimizer, err = encodeOptimizer(network.optimizer); err != nil {
+		return nil, err
+	}
+	if serialized.Preprocessing, err = encodePreprocessing(network.preprocessing); err != nil {
+		return nil, err
+	}
+	if serialized.TargetScaling, err = encodeTargetScaling(network.targetScaling); err != nil {
+		return nil, err
 	}
 	for index, layer := range network.layers {
-		if weightsData, biasesData, errW, errB := encodeFFLayer(layer); errW != nil || errB != nil {
-			if errW != nil {
-				return errW
-			} else {
-				return errB
-			}
-		} else {
-			serialized.Layers[index] = &serializedFFLayer{
-				F:          layer.f.Name(),
-				OutputSize: layer.outputSize,
-				W:          weightsData,
-				B:          biasesData,
-			}
+		weights, biases, parameters := encodeFFLayer(layer)
+		config, err := encodeConfig(layer.f)
+		if err != nil {
+			return nil, err
+		}
+		serialized.Layers[index] = &serializedFFLayer{
+			F:          layer.f.Name(),
+			FConfig:    config,
+			OutputSize: layer.outputSize,
+			W:          weights,
+			B:          biases,
+			P:          parameters,
 		}
 	}
 
-	encoder := json.NewEncoder(file)
-	return encoder.Encode(&serialized)
+	return serialized, nil
 }
 
 
 type FFLayerSpec struct {
 	outputSize int
 	activator Activator
+	initializer Initializer
 }
 type FFNetworkBuilder struct {
 	defaultLearningRate float64
 	inputSize int
 	errorMetric ErrorMetric
 	layers []*FFLayerSpec
+	preprocessing *Preprocessing
+	targetScaling *TargetScaling
+	optimizer Optimizer
+	l1 float64
+	l2 float64
 }
 
 
@@ -184,6 +443,11 @@ func New(defaultLearningRate float64, inputSize int, errorMetric ErrorMetric) *F
 
 
 func (builder *FFNetworkBuilder) AddLayer(outputSize int, activator Activator) *FFNetworkBuilder {
+	return builder.AddInitializedLayer(outputSize, activator, nil)
+}
+
+
+func (builder *FFNetworkBuilder) AddInitializedLayer(outputSize int, activator Activator, initializer Initializer) *FFNetworkBuilder {
 	if outputSize < 1 {
 		panic("output size must be >= 1")
 	}
@@ -192,14 +456,71 @@ func (builder *FFNetworkBuilder) AddLayer(outputSize int, activator Activator) *
 		activator = GetActivator("_default")
 	}
 
+	if initializer == nil {
+		initializer = GetInitializer("_default")
+	}
+
 	builder.layers = append(builder.layers, &FFLayerSpec{
 		outputSize: outputSize,
 		activator: activator,
+		initializer: initializer,
 	})
 	return builder
 }
 
 
+// Sets the update rule (by default, plain SGD)
+func (builder *FFNetworkBuilder) WithOptimizer(optimizer Optimizer) *FFNetworkBuilder {
+	if optimizer != nil {
+		if err := validateOptimizer(optimizer); err != nil {
+			panic(err.Error())
+		}
+	}
+	builder.optimizer = optimizer
+	return builder
+}
+
+
+// Sets the L1 and L2 penalties over the weights
+func (builder *FFNetworkBuilder) WithRegularization(l1, l2 float64) *FFNetworkBuilder {
+	if l1 < 0 || l2 < 0 {
+		panic("regularization penalties must not be negative")
+	}
+
+	builder.l1, builder.l2 = l1, l2
+	return builder
+}
+
+
+// Sets the (already fitted) preprocessing steps for the raw
+//   inputs. Their output size must match the builder's input size.
+func (builder *FFNetworkBuilder) WithPreprocessing(preprocessing *Preprocessing) *FFNetworkBuilder {
+	if preprocessing != nil {
+		if !preprocessing.Fitted() {
+			panic("preprocessing must be fitted")
+		}
+		if preprocessing.OutputSize() != builder.inputSize {
+			panic("preprocessing output size must match the input size")
+		}
+	}
+
+	builder.preprocessing = preprocessing
+	return builder
+}
+
+
+// Sets the (already fitted) target scaling. Its size must match
+//   the size of the last layer, once built.
+func (builder *FFNetworkBuilder) WithTargetScaling(scaling *TargetScaling) *FFNetworkBuilder {
+	if scaling != nil && !scaling.Fitted() {
+		panic("target scaling must be fitted")
+	}
+
+	builder.targetScaling = scaling
+	return builder
+}
+
+
 func (builder *FFNetworkBuilder) CanBuild() bool {
 	return len(builder.layers) > 0
 }
@@ -212,17 +533,21 @@ func (builder *FFNetworkBuilder) Build() *FFNetwork {
 	}
 
 	network := &FFNetwork{
+		preprocessing:       builder.preprocessing,
 		defaultLearningRate: builder.defaultLearningRate,
 		c:                   builder.errorMetric,
 		layers:              make([]*FFLayer, layersCount),
 		rDaDz:               make([]*mat.Dense, layersCount),
 		rDcDa:               make([]*mat.Dense, layersCount),
 		delta:               make([]*mat.Dense, layersCount),
+		l1:                  builder.l1,
+		l2:                  builder.l2,
 	}
+	network.SetOptimizer(builder.optimizer)
 
 	inputSize := builder.inputSize
 	for index, layerSpec := range builder.layers {
-		network.layers[index] = newFFLayer(inputSize, layerSpec.outputSize, layerSpec.activator)
+		network.layers[index] = newFFLayer(inputSize, layerSpec.outputSize, layerSpec.activator, layerSpec.initializer)
 		// here we create the training matrices
 		network.rDaDz[index] = mat.NewDense(layerSpec.outputSize, 1, nil)
 		network.rDcDa[index] = mat.NewDense(layerSpec.outputSize, 1, nil)
@@ -230,5 +555,9 @@ func (builder *FFNetworkBuilder) Build() *FFNetwork {
 		inputSize = layerSpec.outputSize
 	}
 
+	if err := network.SetTargetScaling(builder.targetScaling); err != nil {
+		panic(err.Error())
+	}
+
 	return network
 }
@@ -0,0 +1,94 @@
+package ffnn
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gonum.org/v1/gonum/mat"
+)
+
+// The version of the .ffnn format written by this package. Files
+//   without a version are the original format (version 0), where
+//   weights and biases were opaque gonum MarshalBinary blobs.
+// Version 2 added float32 tensors and the binary container.
+// Version 3 added the parameters of the activators and metrics.
+// Version 4 added the learnable parameters of the activators.
+const FormatVersion = 4
+
+var ErrNewerFormat = errors.New("the file was written by a newer version of the format")
+
+// A migration upgrades, in place, a decoded document from its
+//   version to the next one.
+type migration func(document map[string]json.RawMessage) error
+
+var migrations = map[int]migration{
+	0: migrateFromVersion0,
+	1: migrateFromVersion1,
+	2: migrateFromVersion2,
+	3: migrateFromVersion3,
+}
+
+// Reads the version of a decoded document and upgrades it, one
+//   version at a time, to the current format.
+func migrate(document map[string]json.RawMessage) error {
+	version := 0
+	if raw, found := document["Version"]; found {
+		if err := json.Unmarshal(raw, &version); err != nil {
+			return errors.New(fmt.Sprintf("invalid format version: %v", string(raw)))
+		}
+	}
+	if version > FormatVersion {
+		return fmt.Errorf("%w (file version: %v, supported up to: %v)", ErrNewerFormat, version, FormatVersion)
+	}
+	if version < 0 {
+		return errors.New(fmt.Sprintf("invalid format version: %v", version))
+	}
+
+	for ; version < FormatVersion; version++ {
+		if err := migrations[version](document); err != nil {
+			return fmt.Errorf("migrating from format version %v: %w", version, err)
+		}
+	}
+	document["Version"], _ = json.Marshal(FormatVersion)
+	return nil
+}
+
+// Version 1 replaced the gonum blobs by explicit tensors
+func migrateFromVersion0(document map[string]json.RawMessage) error {
+	var layers []map[string]json.RawMessage
+	if err := json.Unmarshal(document["Layers"], &layers); err != nil {
+		return err
+	}
+	for index, layer := range layers {
+		for _, key := range []string{"W", "B"} {
+			var blob []byte
+			if err := json.Unmarshal(layer[key], &blob); err != nil {
+				return err
+			}
+			m := &mat.Dense{}
+			if err := m.UnmarshalBinary(blob); err != nil {
+				return errors.New(fmt.Sprintf("layer %v %v: %v", index, key, err))
+			}
+			layer[key], _ = json.Marshal(encodeTensor(m))
+		}
+	}
+	document["Layers"], _ = json.Marshal(layers)
+	return nil
+}
+
+// Version 2 only added features, so version 1 documents are valid
+func migrateFromVersion1(document map[string]json.RawMessage) error {
+	return nil
+}
+
+// Version 3 only added the (optional) parameters, so version 2
+//   documents are valid
+func migrateFromVersion2(document map[string]json.RawMessage) error {
+	return nil
+}
+
+// Version 4 only added the (optional) learnable parameters, so
+//   version 3 documents are valid
+func migrateFromVersion3(document map[string]json.RawMessage) error {
+	return nil
+}
@@ -1,6 +1,10 @@
 package ffnn
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"gonum.org/v1/gonum/mat"
 	"../utils/matrices"
 	"../utils/matrices/ops"
@@ -39,6 +43,53 @@ func (s Sigmoid) Derivative(z, da_dz *mat.Dense) *mat.Dense {
 }
 
 
+// Leaky ReLU: z for z > 0, and alpha * z otherwise (alpha >= 0)
+type LeakyReLU struct {
+	Alpha float64
+}
+func NewLeakyReLU(alpha float64) *LeakyReLU {
+	leakyReLU := &LeakyReLU{Alpha: alpha}
+	if err := leakyReLU.Validate(); err != nil {
+		panic(err.Error())
+	}
+	return leakyReLU
+}
+func (l *LeakyReLU) Validate() error {
+	if !(l.Alpha >= 0) || math.IsInf(l.Alpha, 0) {
+		return errors.New(fmt.Sprintf("LeakyReLU alpha must be finite and >= 0, got %v", l.Alpha))
+	}
+	return nil
+}
+func (l *LeakyReLU) Name() string {
+	return "LeakyReLU"
+}
+func (l *LeakyReLU) Base(z, a *mat.Dense) *mat.Dense {
+	return ops.Apply(func(i, j int, x float64) float64 {
+		if x > 0 {
+			return x
+		}
+		return l.Alpha * x
+	}, z, a)
+}
+func (l *LeakyReLU) Derivative(z, da_dz *mat.Dense) *mat.Dense {
+	return ops.Apply(func(i, j int, x float64) float64 {
+		if x > 0 {
+			return 1
+		}
+		return l.Alpha
+	}, z, da_dz)
+}
+func (l *LeakyReLU) MarshalConfig() (json.RawMessage, error) {
+	return json.Marshal(l)
+}
+func (l *LeakyReLU) UnmarshalConfig(config json.RawMessage) error {
+	if err := unmarshalConfig(config, l); err != nil {
+		return err
+	}
+	return l.Validate()
+}
+
+
 // A simple mathematical function and its derivative.
 // While the derivative takes expected and real output
 //   and returns a matrix of values (one for each value),
@@ -80,46 +131,232 @@ func (hse HalfSquaredError) Gradient(a, t, dc_da *mat.Dense) *mat.Dense {
 }
 
 
-var activators = map[string]Activator{
-	"_default": Sigmoid{},
-	"Sigmoid": Sigmoid{},
+// The Huber loss: quadratic for differences up to delta, and
+//   linear beyond, so outliers weigh less than in the HSE (delta > 0)
+type Huber struct {
+	Delta float64
+}
+func NewHuber(delta float64) *Huber {
+	huber := &Huber{Delta: delta}
+	if err := huber.Validate(); err != nil {
+		panic(err.Error())
+	}
+	return huber
+}
+func (h *Huber) Validate() error {
+	if !(h.Delta > 0) || math.IsInf(h.Delta, 0) {
+		return errors.New(fmt.Sprintf("Huber delta must be finite and > 0, got %v", h.Delta))
+	}
+	return nil
+}
+func (h *Huber) Name() string {
+	return "Huber"
+}
+func (h *Huber) Base(a, t *mat.Dense) float64 {
+	rows, columns := t.Dims()
+	difference := ops.Sub(a, t, mat.NewDense(rows, columns, nil))
+	cost := 0.0
+	for i := 0; i < rows; i++ {
+		for j := 0; j < columns; j++ {
+			if d := math.Abs(difference.At(i, j)); d <= h.Delta {
+				cost += d * d / 2
+			} else {
+				cost += h.Delta * (d - h.Delta / 2)
+			}
+		}
+	}
+	return cost
+}
+func (h *Huber) Gradient(a, t, dc_da *mat.Dense) *mat.Dense {
+	// The difference, clipped to [-delta, delta]
+	ops.Sub(a, t, dc_da)
+	return ops.Apply(func(i, j int, d float64) float64 {
+		return math.Max(-h.Delta, math.Min(h.Delta, d))
+	}, dc_da, dc_da)
+}
+func (h *Huber) MarshalConfig() (json.RawMessage, error) {
+	return json.Marshal(h)
+}
+func (h *Huber) UnmarshalConfig(config json.RawMessage) error {
+	if err := unmarshalConfig(config, h); err != nil {
+		return err
+	}
+	return h.Validate()
+}
+
+
+// Implemented by the activators and error metrics having
+//   parameters, so they are saved with the network. Such ones
+//   MUST be registered with a factory (e.g. with
+//   RegisterActivatorFactory) of instances with their default
+//   parameters, which are then unmarshaled into when loading.
+type Configurable interface {
+	MarshalConfig() (json.RawMessage, error)
+	UnmarshalConfig(config json.RawMessage) error
+}
+
+// Unmarshals the parameters, failing on unknown ones (as configs do)
+func unmarshalConfig(config json.RawMessage, function interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(config))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(function); err != nil {
+		return describeDecodeError(err)
+	}
+	return nil
 }
 
-var errorMetrics = map[string]ErrorMetric{
-	"_default": HalfSquaredError{},
-	"HalfSquaredError": HalfSquaredError{},
+// The configuration of a function, if it has any
+func encodeConfig(function interface{}) (json.RawMessage, error) {
+	if configurable, ok := function.(Configurable); ok {
+		return configurable.MarshalConfig()
+	}
+	return nil, nil
 }
 
+func decodeConfig(function interface{}, name string, config json.RawMessage) error {
+	if len(config) == 0 {
+		return nil
+	}
+	if configurable, ok := function.(Configurable); ok {
+		return configurable.UnmarshalConfig(config)
+	}
+	return errors.New(fmt.Sprintf("%v takes no parameters", name))
+}
+
+
+// Factories of the activators and error metrics. Functions
+//   without parameters are registered as instances, and their
+//   factories return them as they are.
+var activators = newRegistry("activator", map[string]interface{}{
+	"_default": func() Activator { return Sigmoid{} },
+	"Sigmoid": func() Activator { return Sigmoid{} },
+	"LeakyReLU": func() Activator { return NewLeakyReLU(0.01) },
+	"PReLU": func() Activator { return NewPReLU(0.25) },
+	"Swish": func() Activator { return NewSwish(1.0) },
+})
+
+var errorMetrics = newRegistry("error metric", map[string]interface{}{
+	"_default": func() ErrorMetric { return HalfSquaredError{} },
+	"HalfSquaredError": func() ErrorMetric { return HalfSquaredError{} },
+	"Huber": func() ErrorMetric { return NewHuber(1.0) },
+})
+
+// Registers an activator without parameters, whose instance is
+//   shared by all the networks. Configurable and learnable ones are
+//   not accepted, since their parameters would be shared too: they
+//   need RegisterActivatorFactory.
 func RegisterActivator(activator Activator) bool {
-	name := activator.Name()
-	if _, found := activators[name]; !found && activator != nil {
-		activators[name] = activator
-		return true
+	if activator == nil {
+		return false
 	}
-	return false
+	if _, ok := activator.(Configurable); ok {
+		return false
+	}
+	if _, ok := activator.(LearnableActivator); ok {
+		return false
+	}
+	return activators.register(activator.Name(), func() Activator { return activator })
 }
 
+// Registers a parameterized activator (see Configurable). The
+//   factory's activators MUST be named after the name, or the
+//   networks using them could not be loaded back.
+func RegisterActivatorFactory(name string, factory func() Activator) bool {
+	if factory == nil {
+		return false
+	}
+	if activator := factory(); activator == nil || activator.Name() != name {
+		return false
+	}
+	return activators.register(name, factory)
+}
+
+// Falls back to the default activator for unknown names. Use
+//   LookupActivator to tell them apart. Parameterized activators
+//   come with their default parameters.
 func GetActivator(name string) Activator {
-	if activator, found := activators[name]; found {
-		return activator
+	return activators.get(name).(func() Activator)()
+}
+
+// Fails with an UnknownNameError for unknown names
+func LookupActivator(name string) (Activator, error) {
+	if factory, err := activators.lookup(name); err != nil {
+		return nil, err
 	} else {
-		return activators["_default"]
+		return factory.(func() Activator)(), nil
 	}
 }
 
+// The registered activator names, sorted
+func ActivatorNames() []string {
+	return activators.names()
+}
+
+// Creates the activator and restores its saved parameters
+func decodeActivator(name string, config json.RawMessage) (Activator, error) {
+	activator, err := LookupActivator(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := decodeConfig(activator, activator.Name(), config); err != nil {
+		return nil, err
+	}
+	return activator, nil
+}
+
+// Registers an error metric without parameters (see
+//   RegisterActivator)
 func RegisterErrorMetric(errorMetric ErrorMetric) bool {
-	name := errorMetric.Name()
-	if _, found := errorMetrics[name]; !found && errorMetric != nil {
-		errorMetrics[name] = errorMetric
-		return true
+	if errorMetric == nil {
+		return false
+	}
+	if _, ok := errorMetric.(Configurable); ok {
+		return false
+	}
+	return errorMetrics.register(errorMetric.Name(), func() ErrorMetric { return errorMetric })
+}
+
+// Registers a parameterized error metric (see Configurable and
+//   RegisterActivatorFactory)
+func RegisterErrorMetricFactory(name string, factory func() ErrorMetric) bool {
+	if factory == nil {
+		return false
 	}
-	return false
+	if errorMetric := factory(); errorMetric == nil || errorMetric.Name() != name {
+		return false
+	}
+	return errorMetrics.register(name, factory)
 }
 
+// Falls back to the default error metric for unknown names. Use
+//   LookupErrorMetric to tell them apart. Parameterized metrics
+//   come with their default parameters.
 func GetErrorMetric(name string) ErrorMetric {
-	if errorMetric, found := errorMetrics[name]; found {
-		return errorMetric
+	return errorMetrics.get(name).(func() ErrorMetric)()
+}
+
+// Fails with an UnknownNameError for unknown names
+func LookupErrorMetric(name string) (ErrorMetric, error) {
+	if factory, err := errorMetrics.lookup(name); err != nil {
+		return nil, err
 	} else {
-		return errorMetrics["_default"]
+		return factory.(func() ErrorMetric)(), nil
+	}
+}
+
+// The registered error metric names, sorted
+func ErrorMetricNames() []string {
+	return errorMetrics.names()
+}
+
+// Creates the error metric and restores its saved parameters
+func decodeErrorMetric(name string, config json.RawMessage) (ErrorMetric, error) {
+	errorMetric, err := LookupErrorMetric(name)
+	if err != nil {
+		return nil, err
 	}
-}
\ No newline at end of file
+	if err := decodeConfig(errorMetric, errorMetric.Name(), config); err != nil {
+		return nil, err
+	}
+	return errorMetric, nil
+}
@@ -0,0 +1,86 @@
+package ffnn
+
+import (
+	"gonum.org/v1/gonum/mat"
+	"../utils/matrices"
+	"math"
+)
+
+// Creates the initial weights (outputSize x inputSize) and
+//   biases (outputSize x 1) of a layer
+type Initializer interface {
+	// Initializer name (key)
+	Name() string
+	// The initial weights and biases
+	Initialize(inputSize, outputSize int) (*mat.Dense, *mat.Dense)
+}
+
+
+// The default: uniform noise in +/- 1/sqrt(inputSize) for both
+//   weights and biases
+type UniformInitializer struct{}
+func (u UniformInitializer) Name() string {
+	return "Uniform"
+}
+func (u UniformInitializer) Initialize(inputSize, outputSize int) (*mat.Dense, *mat.Dense) {
+	bound := 1.0/math.Sqrt(float64(inputSize))
+	return matrices.Noise(outputSize, inputSize, bound), matrices.Noise(outputSize, 1, bound)
+}
+
+
+// Glorot/Xavier uniform weights in +/- sqrt(6/(in + out)), and
+//   zero biases. Suits sigmoid-like activators
+type XavierInitializer struct{}
+func (x XavierInitializer) Name() string {
+	return "Xavier"
+}
+func (x XavierInitializer) Initialize(inputSize, outputSize int) (*mat.Dense, *mat.Dense) {
+	bound := math.Sqrt(6.0/float64(inputSize + outputSize))
+	return matrices.Noise(outputSize, inputSize, bound), mat.NewDense(outputSize, 1, nil)
+}
+
+
+// He normal weights with deviation sqrt(2/in), and zero biases.
+//   Suits ReLU-like activators
+type HeInitializer struct{}
+func (h HeInitializer) Name() string {
+	return "He"
+}
+func (h HeInitializer) Initialize(inputSize, outputSize int) (*mat.Dense, *mat.Dense) {
+	return matrices.NormalNoise(outputSize, inputSize, math.Sqrt(2.0/float64(inputSize))), mat.NewDense(outputSize, 1, nil)
+}
+
+
+var initializers = newRegistry("initializer", map[string]interface{}{
+	"_default": UniformInitializer{},
+	"Uniform": UniformInitializer{},
+	"Xavier": XavierInitializer{},
+	"He": HeInitializer{},
+})
+
+func RegisterInitializer(initializer Initializer) bool {
+	if initializer == nil {
+		return false
+	}
+	return initializers.register(initializer.Name(), initializer)
+}
+
+// Falls back to the default initializer for unknown names. Use
+//   LookupInitializer to tell them apart.
+func GetInitializer(name string) Initializer {
+	return initializers.get(name).(Initializer)
+}
+
+// Fails with an UnknownNameError for unknown names
+func LookupInitializer(name string) (Initializer, error) {
+	if initializer, err := initializers.lookup(name); err != nil {
+		return nil, err
+	} else {
+		return initializer.(Initializer), nil
+	}
+}
+
+// The registered initializer names, sorted
+func InitializerNames() []string {
+	return initializers.names()
+}
@@ -0,0 +1,127 @@
+package ffnn
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrMissingChecksum   = errors.New("the network has no checksum")
+	ErrChecksumMismatch  = errors.New("the network checksum does not match its content (corrupted or tampered)")
+	ErrUnsigned          = errors.New("the network is not signed")
+	ErrSignatureMismatch = errors.New("the network signature does not match (tampered, or signed with another key)")
+)
+
+// How to load a network. The zero value verifies the checksum
+//   when present, and ignores any signature.
+type LoadOptions struct {
+	// Fails with ErrMissingChecksum on files without checksum
+	//   (i.e. written before checksums existed)
+	RequireChecksum bool
+	// When present, the file MUST be signed with this key
+	VerificationKey []byte
+}
+
+// The integrity fields are excluded from the content they cover
+var integrityFields = []string{"Checksum", "Signature"}
+
+// A canonical JSON form of the document (which may be a struct
+//   or a generic map), so the writer and the reader compute the
+//   same bytes regardless of how the document was represented.
+func canonical(document interface{}) ([]byte, error) {
+	data, err := json.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+	var generic map[string]interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil, err
+	}
+	for _, field := range integrityFields {
+		delete(generic, field)
+	}
+	return json.Marshal(generic)
+}
+
+// SHA-256 over the canonical document, followed by the payloads
+//   stored out of it (for binary containers)
+func digest(document interface{}, payloads [][]byte) ([]byte, error) {
+	data, err := canonical(document)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.New()
+	hash.Write(data)
+	for _, payload := range payloads {
+		hash.Write(payload)
+	}
+	return hash.Sum(nil), nil
+}
+
+func sign(sum []byte, key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(sum)
+	return mac.Sum(nil)
+}
+
+// Sets the checksum and, when a key is given, the signature, of
+//   a document about to be written
+func seal(serialized *serializedFFNetwork, payloads [][]byte, key []byte) error {
+	serialized.Checksum, serialized.Signature = "", ""
+	sum, err := digest(serialized, payloads)
+	if err != nil {
+		return err
+	}
+	serialized.Checksum = hex.EncodeToString(sum)
+	if len(key) > 0 {
+		serialized.Signature = hex.EncodeToString(sign(sum, key))
+	}
+	return nil
+}
+
+// Checks a document as read, before any migration
+func verify(document map[string]json.RawMessage, payloads [][]byte, options LoadOptions) error {
+	var checksum, signature string
+	if raw, found := document["Checksum"]; found {
+		if err := json.Unmarshal(raw, &checksum); err != nil {
+			return fmt.Errorf("%w: invalid checksum field", ErrChecksumMismatch)
+		}
+	}
+	if raw, found := document["Signature"]; found {
+		if err := json.Unmarshal(raw, &signature); err != nil {
+			return fmt.Errorf("%w: invalid signature field", ErrSignatureMismatch)
+		}
+	}
+
+	if checksum == "" {
+		if len(options.VerificationKey) > 0 {
+			return ErrUnsigned
+		}
+		if options.RequireChecksum {
+			return ErrMissingChecksum
+		}
+		return nil
+	}
+
+	sum, err := digest(document, payloads)
+	if err != nil {
+		return err
+	}
+	if expected, err := hex.DecodeString(checksum); err != nil || !hmac.Equal(expected, sum) {
+		return ErrChecksumMismatch
+	}
+
+	if len(options.VerificationKey) > 0 {
+		if signature == "" {
+			return ErrUnsigned
+		}
+		if expected, err := hex.DecodeString(signature); err != nil || !hmac.Equal(expected, sign(sum, options.VerificationKey)) {
+			return ErrSignatureMismatch
+		}
+	}
+	return nil
+}
@@ -1,12 +1,10 @@
 package ffnn
 
 import (
-	"gonum.org/v1/gonum/mat"
-	"../utils/matrices"
-	"../utils/matrices/ops"
-	"math"
 	"errors"
 	"fmt"
+	"gonum.org/v1/gonum/mat"
+	"../utils/matrices/ops"
 )
 
 type FFLayer struct {
@@ -50,6 +48,14 @@ func makeFFLayer(inputSize, outputSize int, activator Activator, w *mat.Dense, b
 	z := mat.NewDense(outputSize, 1, nil)
 	// Creating an undefined a layer
 	a := mat.NewDense(outputSize, 1, nil)
+	// Creating the learnable parameters of the activator, if any,
+	//   in a clone, so instances shared by layers (or networks)
+	//   don't share their parameters
+	if learnable, ok := activator.(LearnableActivator); ok {
+		learnable = learnable.Clone()
+		learnable.InitParameters(outputSize)
+		activator = learnable
+	}
 
 	return &FFLayer{
 		inputSize:  inputSize,
@@ -64,47 +70,49 @@ func makeFFLayer(inputSize, outputSize int, activator Activator, w *mat.Dense, b
 	}
 }
 
-func newFFLayer(inputSize, outputSize int, activator Activator) *FFLayer {
-	bound := 1.0/math.Sqrt(float64(inputSize))
+func newFFLayer(inputSize, outputSize int, activator Activator, initializer Initializer) *FFLayer {
 	// Creating a noisy w/b layer
-	w := matrices.Noise(outputSize, inputSize, bound)
-	b := matrices.Noise(outputSize, 1, bound)
+	w, b := initializer.Initialize(inputSize, outputSize)
 	return makeFFLayer(inputSize, outputSize, activator, w, b)
 }
 
-func unMarshall(expectedRows, expectedColumns int, data []byte, element string) (*mat.Dense, error) {
-	m := mat.NewDense(expectedRows, expectedColumns, nil)
-	m.Reset()
-	if err := m.UnmarshalBinary(data); err != nil {
-		return nil, err
-	} else {
-		rows, columns := m.Dims()
-		if rows != expectedRows || columns != expectedColumns {
-			return nil, errors.New(fmt.Sprintf(
-				"layer %v size mismatch between requested and unmarshaled", element,
-			))
-		}
-	}
-	return m, nil
-}
-
-func decodeFFLayer(inputSize, outputSize int, activator Activator, wData, bData []byte) (*FFLayer, error) {
+func decodeFFLayer(
+	inputSize, outputSize int, activator Activator, wTensor, bTensor *serializedTensor, pTensors []*serializedTensor,
+) (*FFLayer, error) {
 	// Loading the w from memory
 	var w, b *mat.Dense
 	var err error
-	if w, err = unMarshall(outputSize, inputSize, wData, "weights"); err != nil {
+	if w, err = decodeTensor(wTensor, outputSize, inputSize, "weights"); err != nil {
 		return nil, err
 	}
-	if b, err = unMarshall(outputSize, 1, bData, "biases"); err != nil {
+	if b, err = decodeTensor(bTensor, outputSize, 1, "biases"); err != nil {
 		return nil, err
 	}
-	return makeFFLayer(inputSize, outputSize, activator, w, b), nil
+	layer := makeFFLayer(inputSize, outputSize, activator, w, b)
+	// Loading the learned parameters over the initial ones
+	parameters := activatorParameters(layer.f)
+	if len(pTensors) != len(parameters) {
+		return nil, errors.New(fmt.Sprintf(
+			"activator %v has %v learnable parameters, but %v were saved", activator.Name(), len(parameters), len(pTensors),
+		))
+	}
+	for index, parameter := range parameters {
+		rows, columns := parameter.Dims()
+		if p, err := decodeTensor(pTensors[index], rows, columns, fmt.Sprintf("activator parameter %v", index)); err != nil {
+			return nil, err
+		} else {
+			parameter.Copy(p)
+		}
+	}
+	return layer, nil
 }
 
-func encodeFFLayer(layer *FFLayer) ([]byte, []byte, error, error) {
-	w, errW := layer.w.MarshalBinary()
-	b, errB := layer.b.MarshalBinary()
-	return w, b, errW, errB
+func encodeFFLayer(layer *FFLayer) (*serializedTensor, *serializedTensor, []*serializedTensor) {
+	var parameters []*serializedTensor
+	for _, parameter := range activatorParameters(layer.f) {
+		parameters = append(parameters, encodeTensor(parameter))
+	}
+	return encodeTensor(layer.w), encodeTensor(layer.b), parameters
 }
 
 func (layer *FFLayer) InputSize() int {
@@ -119,6 +127,10 @@ func (layer *FFLayer) Weights() *mat.Dense {
 	return layer.w
 }
 
+func (layer *FFLayer) Biases() *mat.Dense {
+	return layer.b
+}
+
 func (layer *FFLayer) Inputs() *mat.Dense {
 	return layer.i;
 }
@@ -142,3 +154,11 @@ func (layer *FFLayer) Forward(inputs *mat.Dense) {
 	// Compute the a = f(wi + b).
 	layer.f.Base(ops.Add(ops.Mul(layer.w, layer.i, layer.wi), layer.b, layer.z), layer.a)
 }
+
+// Like Forward, but into new matrices, leaving the layer state
+//   untouched. Returns the activations.
+func (layer *FFLayer) predict(inputs *mat.Dense) *mat.Dense {
+	z := mat.NewDense(layer.outputSize, 1, nil)
+	ops.Add(ops.Mul(layer.w, inputs, z), layer.b, z)
+	return layer.f.Base(z, mat.NewDense(layer.outputSize, 1, nil))
+}
@@ -0,0 +1,147 @@
+package ffnn
+
+import (
+	"encoding/json"
+	"gonum.org/v1/gonum/mat"
+	"../utils/matrices"
+	"../utils/matrices/ops"
+)
+
+// An activator with parameters learned during the training, which
+//   are updated by the network's optimizer (like the weights) and
+//   saved with the layer. Since the parameters are sized after the
+//   layer, each layer gets its own clone of the instance given.
+type LearnableActivator interface {
+	Activator
+	// A new instance (of the same type) with the same settings,
+	//   and without parameters
+	Clone() LearnableActivator
+	// Creates the parameters, with their initial values, for a
+	//   layer with the given output size
+	InitParameters(size int)
+	// The parameters, which the optimizer updates in place
+	Parameters() []*mat.Dense
+	// Computes the gradients of the cost wrt the parameters, given
+	//   the weighted inputs and the gradient of the cost wrt the
+	//   activations, into matrices sized as the parameters
+	ParameterGradients(z, dc_da *mat.Dense, gradients []*mat.Dense)
+}
+
+// The learnable parameters of an activator, if any
+func activatorParameters(activator Activator) []*mat.Dense {
+	if learnable, ok := activator.(LearnableActivator); ok {
+		return learnable.Parameters()
+	}
+	return nil
+}
+
+
+// PReLU: z for z > 0, and alpha * z otherwise, with an alpha per
+//   neuron, learned from Initial
+type PReLU struct {
+	Initial float64
+	alpha   *mat.Dense
+}
+func NewPReLU(initial float64) *PReLU {
+	return &PReLU{Initial: initial}
+}
+func (p *PReLU) Clone() LearnableActivator {
+	return &PReLU{Initial: p.Initial}
+}
+func (p *PReLU) Name() string {
+	return "PReLU"
+}
+func (p *PReLU) Base(z, a *mat.Dense) *mat.Dense {
+	return ops.Apply(func(i, j int, x float64) float64 {
+		if x > 0 {
+			return x
+		}
+		return p.alpha.At(i, 0) * x
+	}, z, a)
+}
+func (p *PReLU) Derivative(z, da_dz *mat.Dense) *mat.Dense {
+	return ops.Apply(func(i, j int, x float64) float64 {
+		if x > 0 {
+			return 1
+		}
+		return p.alpha.At(i, 0)
+	}, z, da_dz)
+}
+func (p *PReLU) InitParameters(size int) {
+	p.alpha = matrices.Fill(size, 1, p.Initial)
+}
+func (p *PReLU) Parameters() []*mat.Dense {
+	return []*mat.Dense{p.alpha}
+}
+func (p *PReLU) ParameterGradients(z, dc_da *mat.Dense, gradients []*mat.Dense) {
+	// da/dalpha is z for z <= 0, and 0 otherwise
+	gradients[0].Apply(func(i, j int, x float64) float64 {
+		if x > 0 {
+			return 0
+		}
+		return dc_da.At(i, j) * x
+	}, z)
+}
+func (p *PReLU) MarshalConfig() (json.RawMessage, error) {
+	return json.Marshal(p)
+}
+func (p *PReLU) UnmarshalConfig(config json.RawMessage) error {
+	return unmarshalConfig(config, p)
+}
+
+
+// Swish: z * sigmoid(beta * z), with a single beta for the whole
+//   layer, learned from Initial
+type Swish struct {
+	Initial float64
+	beta    *mat.Dense
+}
+func NewSwish(initial float64) *Swish {
+	return &Swish{Initial: initial}
+}
+func (s *Swish) Clone() LearnableActivator {
+	return &Swish{Initial: s.Initial}
+}
+func (s *Swish) Name() string {
+	return "Swish"
+}
+func (s *Swish) Base(z, a *mat.Dense) *mat.Dense {
+	beta := s.beta.At(0, 0)
+	return ops.Apply(func(i, j int, x float64) float64 {
+		return x * sigmoid(i, j, beta * x)
+	}, z, a)
+}
+func (s *Swish) Derivative(z, da_dz *mat.Dense) *mat.Dense {
+	beta := s.beta.At(0, 0)
+	return ops.Apply(func(i, j int, x float64) float64 {
+		sig := sigmoid(i, j, beta * x)
+		return sig + beta * x * sig * (1 - sig)
+	}, z, da_dz)
+}
+func (s *Swish) InitParameters(size int) {
+	s.beta = matrices.Fill(1, 1, s.Initial)
+}
+func (s *Swish) Parameters() []*mat.Dense {
+	return []*mat.Dense{s.beta}
+}
+func (s *Swish) ParameterGradients(z, dc_da *mat.Dense, gradients []*mat.Dense) {
+	// da/dbeta is z^2 * sigmoid(beta * z) * (1 - sigmoid(beta * z)),
+	//   summed over the neurons since they share beta
+	beta := s.beta.At(0, 0)
+	rows, columns := z.Dims()
+	sum := 0.0
+	for i := 0; i < rows; i++ {
+		for j := 0; j < columns; j++ {
+			x := z.At(i, j)
+			sig := sigmoid(i, j, beta * x)
+			sum += dc_da.At(i, j) * x * x * sig * (1 - sig)
+		}
+	}
+	gradients[0].Set(0, 0, sum)
+}
+func (s *Swish) MarshalConfig() (json.RawMessage, error) {
+	return json.Marshal(s)
+}
+func (s *Swish) UnmarshalConfig(config json.RawMessage) error {
+	return unmarshalConfig(config, s)
+}
@@ -0,0 +1,53 @@
+package ffnn
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+)
+
+// The provenance of a network: a summary of its training history,
+//   saved with it. Trainings resumed from a network (or checkpoint)
+//   are expected to update it, accumulating the counters.
+type Metadata struct {
+	// Completed epochs and training updates, in all the sessions
+	Epochs int
+	Steps  int
+	// Wall time spent training, in seconds
+	TrainingSeconds float64
+	// The metrics of the last completed epoch, by name (e.g. "loss").
+	//   The non-finite ones (as a diverged loss) are not saved.
+	Metrics map[string]float64 `json:",omitempty"`
+	// Fingerprints of the datasets used, by role (e.g. "train"), as
+	//   "sha256:<hex>"
+	Datasets map[string]string `json:",omitempty"`
+	// The training settings, by name (e.g. "optimizer")
+	Hyperparameters map[string]string `json:",omitempty"`
+	// When the training history was last updated
+	Updated time.Time
+}
+
+// Leaves the non-finite metrics out, since JSON cannot represent
+//   them (and the network must be saveable anyway)
+func (metadata Metadata) MarshalJSON() ([]byte, error) {
+	type plain Metadata
+	encoded := plain(metadata)
+	if len(metadata.Metrics) > 0 {
+		encoded.Metrics = map[string]float64{}
+		for name, value := range metadata.Metrics {
+			if !math.IsNaN(value) && !math.IsInf(value, 0) {
+				encoded.Metrics[name] = value
+			}
+		}
+	}
+	return json.Marshal(encoded)
+}
+
+// The metadata, or nil when the network has none
+func (network *FFNetwork) Metadata() *Metadata {
+	return network.metadata
+}
+
+func (network *FFNetwork) SetMetadata(metadata *Metadata) {
+	network.metadata = metadata
+}
@@ -3,9 +3,18 @@ package ffnn
 import (
 	"gonum.org/v1/gonum/mat"
 	"../utils/matrices/ops"
+	"errors"
+	"fmt"
+	"math"
 )
 
 type FFNetwork struct {
+	// Optional preprocessing steps applied to the raw inputs,
+	//   before the first layer.
+	preprocessing *Preprocessing
+	// Optional scaling of the targets. The network is trained
+	//   against scaled targets, and its outputs are mapped back.
+	targetScaling *TargetScaling
 	// The layers, in strict order.
 	layers []*FFLayer
 	// ********************************
@@ -24,17 +33,140 @@ type FFNetwork struct {
 	// Will have the sizes of corresponding layers' weighted i.
 	// It is = dc/dz = dc/da (*) da/dz.
 	delta []*mat.Dense
+	// The update rule for the weights and biases.
+	optimizer Optimizer
+	// The optimizer moments, per layer: first for the weights,
+	//   then for the biases, then for the activator parameters.
+	moments [][][]*mat.Dense
+	// How many updates were done, needed by some optimizers.
+	step int
+	// L1 and L2 penalties over the weights (not the biases).
+	l1 float64
+	l2 float64
+	// The checksum of the file it was loaded from, if any.
+	checksum string
+	// The squared L2 norm of the gradients of the last update.
+	gradientSquares float64
+	// Optional provenance, saved with the network.
+	metadata *Metadata
 }
 
 func (network *FFNetwork) Layer(index int) *FFLayer {
 	return network.layers[index]
 }
 
+func (network *FFNetwork) LayersCount() int {
+	return len(network.layers)
+}
+
+// How many training updates were done
+func (network *FFNetwork) Step() int {
+	return network.step
+}
+
+// The checksum of the file the network was loaded from (empty
+//   for new networks, or files without checksum)
+func (network *FFNetwork) Checksum() string {
+	return network.checksum
+}
+
+func (network *FFNetwork) ErrorMetric() ErrorMetric {
+	return network.c
+}
+
 func (network *FFNetwork) DefaultLearningRate() float64 {
 	return network.defaultLearningRate
 }
 
+// The size of the raw inputs, before the preprocessing (if any)
+func (network *FFNetwork) InputSize() int {
+	if network.preprocessing != nil {
+		return network.preprocessing.InputSize()
+	}
+	return network.layers[0].inputSize
+}
+
+func (network *FFNetwork) Preprocessing() *Preprocessing {
+	return network.preprocessing
+}
+
+// Attaches (or, with nil, removes) the preprocessing, which must
+//   be fitted and produce inputs the size of the first layer's.
+func (network *FFNetwork) SetPreprocessing(preprocessing *Preprocessing) error {
+	if preprocessing != nil {
+		if !preprocessing.Fitted() {
+			return errors.New("preprocessing must be fitted")
+		}
+		if size := preprocessing.OutputSize(); size != network.layers[0].inputSize {
+			return errors.New(fmt.Sprintf(
+				"preprocessing output size %v does not match network input size %v", size, network.layers[0].inputSize,
+			))
+		}
+	}
+	network.preprocessing = preprocessing
+	return nil
+}
+
+func (network *FFNetwork) TargetScaling() *TargetScaling {
+	return network.targetScaling
+}
+
+// Attaches (or, with nil, removes) the target scaling, which must
+//   be fitted and match the size of the last layer's output.
+func (network *FFNetwork) SetTargetScaling(scaling *TargetScaling) error {
+	if scaling != nil {
+		if !scaling.Fitted() {
+			return errors.New("target scaling must be fitted")
+		}
+		if size, outputSize := scaling.OutputSize(), network.layers[len(network.layers) - 1].outputSize; size != outputSize {
+			return errors.New(fmt.Sprintf(
+				"target scaling size %v does not match network output size %v", size, outputSize,
+			))
+		}
+	}
+	network.targetScaling = scaling
+	return nil
+}
+
+// Maps the outputs back into the targets' original units. The
+//   result is a new matrix when a target scaling is present.
+func (network *FFNetwork) unscale(output *mat.Dense) *mat.Dense {
+	if network.targetScaling != nil {
+		return network.targetScaling.Inverse(output)
+	}
+	return output
+}
+
+func (network *FFNetwork) scale(expectedOutput *mat.Dense) *mat.Dense {
+	if network.targetScaling != nil {
+		return network.targetScaling.Transform(expectedOutput)
+	}
+	return expectedOutput
+}
+
 func (network *FFNetwork) Forward(input *mat.Dense) *mat.Dense {
+	return network.unscale(network.forward(input))
+}
+
+// The outputs for the input, as Forward, but without keeping any
+//   state in the layers. Thus it is safe for concurrent use, as
+//   long as the network is not trained (or changed) meanwhile.
+func (network *FFNetwork) Predict(input *mat.Dense) *mat.Dense {
+	if network.preprocessing != nil {
+		input = network.preprocessing.Transform(input)
+	}
+	for _, layer := range network.layers {
+		input = layer.predict(input)
+	}
+	return network.unscale(input)
+}
+
+// The forward pass itself, which leaves the outputs in network
+//   units (i.e. not unscaled)
+func (network *FFNetwork) forward(input *mat.Dense) *mat.Dense {
+	if network.preprocessing != nil {
+		input = network.preprocessing.Transform(input)
+	}
 	for _, layer := range network.layers {
 		layer.Forward(input)
 		input = layer.a
@@ -117,8 +249,7 @@ func (network *FFNetwork) opDeltaInNonLastLayer(
 // Now, to fix the layers!
 func (network *FFNetwork) fixLayer(layerIndex int, learningRate float64) {
 	layer := network.layers[layerIndex]
-	weights := layer.w
-	biases := layer.b
+	moments := network.layerMoments(layerIndex)
 
 	// Cartesian product of i and delta
 	iT := layer.i.T()
@@ -126,18 +257,127 @@ func (network *FFNetwork) fixLayer(layerIndex int, learningRate float64) {
 	rows, _ := delta.Dims() // rows = n. of errors (neurons)
 	_, columns := iT.Dims() // columns = n. of inputs (or former a)
 	deltaXiT := mat.NewDense(rows, columns, nil) // size = n. of errors x n. of inputs
-	delta_ := mat.NewDense(rows, 1, nil) // size = n. of errors x n. of inputs
 	// Op1 Matrix Size: (layer.outputSize rows, 1 column)
 	// Op2 Matrix Size: (1 row, layer.inputSize columns)
 	// Result Matrix Size: (layer.outputSize rows, layer.inputSize column)
-	// Finally, modify the widths and bias by subtracting the scaled delta
-	weights.Sub(weights, ops.Scale(learningRate, ops.Mul(delta, iT, deltaXiT), deltaXiT))
-	biases.Sub(biases, ops.Scale(learningRate, delta, delta_))
+	// This is the gradient of the weights, plus their penalties
+	network.regularize(layer.w, ops.Mul(delta, iT, deltaXiT))
+	network.gradientSquares += squares(deltaXiT) + squares(delta)
+	// Finally, let the optimizer modify the weights and biases
+	network.optimizer.Update(layer.w, deltaXiT, moments[0], network.step, learningRate)
+	network.optimizer.Update(layer.b, delta, moments[1], network.step, learningRate)
+	// And the learnable parameters of the activator, if any, which
+	//   follow from dc/da (as dc/dz does)
+	if learnable, ok := layer.f.(LearnableActivator); ok {
+		parameters := learnable.Parameters()
+		gradients := make([]*mat.Dense, len(parameters))
+		for index, parameter := range parameters {
+			rows, columns := parameter.Dims()
+			gradients[index] = mat.NewDense(rows, columns, nil)
+		}
+		learnable.ParameterGradients(layer.z, network.rDcDa[layerIndex], gradients)
+		for index, parameter := range parameters {
+			network.gradientSquares += squares(gradients[index])
+			network.optimizer.Update(parameter, gradients[index], moments[2 + index], network.step, learningRate)
+		}
+	}
+}
+
+func squares(m *mat.Dense) float64 {
+	sum := 0.0
+	rows, columns := m.Dims()
+	for i := 0; i < rows; i++ {
+		for j := 0; j < columns; j++ {
+			sum += m.At(i, j) * m.At(i, j)
+		}
+	}
+	return sum
+}
+
+// The L2 norm of all the gradients (weights, biases and activator
+//   parameters) of the last training update
+func (network *FFNetwork) GradientNorm() float64 {
+	return math.Sqrt(network.gradientSquares)
+}
+
+// Adds the gradient of the L1 and L2 penalties to the gradient
+func (network *FFNetwork) regularize(weights, gradient *mat.Dense) {
+	if network.l1 == 0 && network.l2 == 0 {
+		return
+	}
+	gradient.Apply(func(i, j int, g float64) float64 {
+		w := weights.At(i, j)
+		sign := 0.0
+		if w > 0 {
+			sign = 1
+		} else if w < 0 {
+			sign = -1
+		}
+		return g + network.l1 * sign + network.l2 * w
+	}, gradient)
+}
+
+// The moments for the weights, biases and activator parameters
+//   (if any) of a layer, created on demand (as zeros) according to
+//   the current optimizer.
+func (network *FFNetwork) layerMoments(layerIndex int) [][]*mat.Dense {
+	if network.moments == nil {
+		network.moments = make([][][]*mat.Dense, len(network.layers))
+	}
+	if network.moments[layerIndex] == nil {
+		layer := network.layers[layerIndex]
+		parameters := append([]*mat.Dense{layer.w, layer.b}, activatorParameters(layer.f)...)
+		count := network.optimizer.Moments()
+		moments := make([][]*mat.Dense, len(parameters))
+		for p, parameter := range parameters {
+			rows, columns := parameter.Dims()
+			moments[p] = make([]*mat.Dense, count)
+			for index := 0; index < count; index++ {
+				moments[p][index] = mat.NewDense(rows, columns, nil)
+			}
+		}
+		network.moments[layerIndex] = moments
+	}
+	return network.moments[layerIndex]
+}
+
+func (network *FFNetwork) Optimizer() Optimizer {
+	return network.optimizer
+}
+
+// Changes the optimizer, discarding the current moments
+func (network *FFNetwork) SetOptimizer(optimizer Optimizer) {
+	if optimizer == nil {
+		optimizer = defaultOptimizer()
+	}
+	network.optimizer = optimizer
+	network.moments = nil
+	network.step = 0
 }
 
+// The L1 and L2 penalties over the weights
+func (network *FFNetwork) Regularization() (float64, float64) {
+	return network.l1, network.l2
+}
+
+func (network *FFNetwork) SetRegularization(l1, l2 float64) error {
+	if l1 < 0 || l2 < 0 {
+		return errors.New("regularization penalties must not be negative")
+	}
+	network.l1, network.l2 = l1, l2
+	return nil
+}
+
+// Expected outputs are in the targets' original units, but the
+//   cost is computed in network units.
 func (network *FFNetwork) Test(input *mat.Dense, expectedOutput *mat.Dense) (*mat.Dense, float64) {
+	output, cost := network.test(input, network.scale(expectedOutput))
+	return network.unscale(output), cost
+}
+
+func (network *FFNetwork) test(input *mat.Dense, expectedOutput *mat.Dense) (*mat.Dense, float64) {
 	// Get the outputs by running a normal forward, and the cost (absolute error)
-	output := network.Forward(input)
+	output := network.forward(input)
 	return output, network.c.Base(output, expectedOutput)
 }
 
@@ -148,6 +388,8 @@ func (network *FFNetwork) adjust(expectedOutput *mat.Dense, learningRate float64
 		network.opDeltaInNonLastLayer(index)
 	}
 	// And finally, after we know all the errors (which are vertical rows), fix the layers
+	network.step++
+	network.gradientSquares = 0
 	for index := 0; index < layersCount; index++ {
 		network.fixLayer(index, learningRate)
 	}
@@ -155,10 +397,11 @@ func (network *FFNetwork) adjust(expectedOutput *mat.Dense, learningRate float64
 
 func (network *FFNetwork) TrainWithRate(input *mat.Dense, expectedOutput *mat.Dense, learningRate float64) (*mat.Dense, float64) {
 	// Get the outputs by running a normal forward, and the cost (absolute error)
-	output, cost := network.Test(input, expectedOutput)
+	expectedOutput = network.scale(expectedOutput)
+	output, cost := network.test(input, expectedOutput)
 	// Now compute the errors backward, and adjust using a learning rate
 	network.adjust(expectedOutput, learningRate)
-	return output, cost
+	return network.unscale(output), cost
 }
 
 func (network *FFNetwork) Train(input *mat.Dense, expectedOutput *mat.Dense) (*mat.Dense, float64) {
@@ -0,0 +1,209 @@
+package ffnn
+
+import (
+	"encoding/json"
+	"errors"
+	"gonum.org/v1/gonum/mat"
+	"math"
+)
+
+// An update rule for the trainable parameters. Optimizers hold
+//   no per-parameter state by themselves: the network keeps, for
+//   each parameter, as many moment matrices (with the size of the
+//   parameter) as the optimizer requests. Since parameterized
+//   optimizers are saved with the network, they MUST be
+//   JSON-marshalable structs with exported fields, and
+//   registered by name.
+type Optimizer interface {
+	// Optimizer name (key)
+	Name() string
+	// How many moment matrices are needed per parameter
+	Moments() int
+	// Updates the parameter given its gradient (which MUST NOT be
+	//   modified), its moments and the 1-based step number
+	Update(parameter, gradient *mat.Dense, moments []*mat.Dense, step int, learningRate float64)
+}
+
+
+// Implemented by the optimizers whose parameters have valid ranges,
+//   so the ones read from configs and files can be checked
+type ValidatedOptimizer interface {
+	Optimizer
+	Validate() error
+}
+
+func validateOptimizer(optimizer Optimizer) error {
+	if validated, ok := optimizer.(ValidatedOptimizer); ok {
+		return validated.Validate()
+	}
+	return nil
+}
+
+func validBeta(beta float64) bool {
+	return beta >= 0 && beta < 1
+}
+
+
+// Plain gradient descent: p -= rate * g
+type SGD struct{}
+func (s *SGD) Name() string {
+	return "SGD"
+}
+func (s *SGD) Moments() int {
+	return 0
+}
+func (s *SGD) Update(parameter, gradient *mat.Dense, moments []*mat.Dense, step int, learningRate float64) {
+	rows, columns := parameter.Dims()
+	scaled := mat.NewDense(rows, columns, nil)
+	scaled.Scale(learningRate, gradient)
+	parameter.Sub(parameter, scaled)
+}
+
+
+// Gradient descent with momentum: v = beta * v + g; p -= rate * v
+type Momentum struct {
+	Beta float64
+}
+func NewMomentum(beta float64) *Momentum {
+	momentum := &Momentum{Beta: beta}
+	if err := momentum.Validate(); err != nil {
+		panic(err.Error())
+	}
+	return momentum
+}
+func (m *Momentum) Validate() error {
+	if !validBeta(m.Beta) {
+		return errors.New("momentum beta must be in [0, 1)")
+	}
+	return nil
+}
+func (m *Momentum) Name() string {
+	return "Momentum"
+}
+func (m *Momentum) Moments() int {
+	return 1
+}
+func (m *Momentum) Update(parameter, gradient *mat.Dense, moments []*mat.Dense, step int, learningRate float64) {
+	velocity := moments[0]
+	velocity.Scale(m.Beta, velocity)
+	velocity.Add(velocity, gradient)
+	rows, columns := parameter.Dims()
+	scaled := mat.NewDense(rows, columns, nil)
+	scaled.Scale(learningRate, velocity)
+	parameter.Sub(parameter, scaled)
+}
+
+
+// Adam, with bias-corrected first and second moments
+type Adam struct {
+	Beta1   float64
+	Beta2   float64
+	Epsilon float64
+}
+func NewAdam(beta1, beta2, epsilon float64) *Adam {
+	adam := &Adam{Beta1: beta1, Beta2: beta2, Epsilon: epsilon}
+	if err := adam.Validate(); err != nil {
+		panic(err.Error())
+	}
+	return adam
+}
+// Betas of 1 would make the bias corrections 0
+func (a *Adam) Validate() error {
+	if !validBeta(a.Beta1) || !validBeta(a.Beta2) {
+		return errors.New("Adam betas must be in [0, 1)")
+	}
+	if !(a.Epsilon > 0) {
+		return errors.New("Adam epsilon must be > 0")
+	}
+	return nil
+}
+func (a *Adam) Name() string {
+	return "Adam"
+}
+func (a *Adam) Moments() int {
+	return 2
+}
+func (a *Adam) Update(parameter, gradient *mat.Dense, moments []*mat.Dense, step int, learningRate float64) {
+	m, v := moments[0], moments[1]
+	correction1 := 1 - math.Pow(a.Beta1, float64(step))
+	correction2 := 1 - math.Pow(a.Beta2, float64(step))
+	rows, columns := parameter.Dims()
+	for i := 0; i < rows; i++ {
+		for j := 0; j < columns; j++ {
+			g := gradient.At(i, j)
+			mij := a.Beta1 * m.At(i, j) + (1 - a.Beta1) * g
+			vij := a.Beta2 * v.At(i, j) + (1 - a.Beta2) * g * g
+			m.Set(i, j, mij)
+			v.Set(i, j, vij)
+			parameter.Set(i, j, parameter.At(i, j) - learningRate * (mij / correction1) / (math.Sqrt(vij / correction2) + a.Epsilon))
+		}
+	}
+}
+
+
+// Factories of optimizers with their default parameters
+var optimizers = newRegistry("optimizer", map[string]interface{}{
+	"_default": func() Optimizer { return &SGD{} },
+	"SGD": func() Optimizer { return &SGD{} },
+	"Momentum": func() Optimizer { return NewMomentum(0.9) },
+	"Adam": func() Optimizer { return NewAdam(0.9, 0.999, 1e-8) },
+})
+
+func RegisterOptimizer(name string, factory func() Optimizer) bool {
+	if factory == nil {
+		return false
+	}
+	return optimizers.register(name, factory)
+}
+
+// Creates an optimizer with its default parameters. Fails with an
+//   UnknownNameError for unknown names.
+func NewOptimizer(name string) (Optimizer, error) {
+	if factory, err := optimizers.lookup(name); err != nil {
+		return nil, err
+	} else {
+		return factory.(func() Optimizer)(), nil
+	}
+}
+
+func defaultOptimizer() Optimizer {
+	return optimizers.get("_default").(func() Optimizer)()
+}
+
+// The registered optimizer names, sorted
+func OptimizerNames() []string {
+	return optimizers.names()
+}
+
+
+type serializedOptimizer struct {
+	Name   string
+	Params json.RawMessage
+}
+
+func encodeOptimizer(optimizer Optimizer) (*serializedOptimizer, error) {
+	if params, err := json.Marshal(optimizer); err != nil {
+		return nil, err
+	} else {
+		return &serializedOptimizer{Name: optimizer.Name(), Params: params}, nil
+	}
+}
+
+func decodeOptimizer(serialized *serializedOptimizer) (Optimizer, error) {
+	if serialized == nil {
+		return defaultOptimizer(), nil
+	}
+	optimizer, err := NewOptimizer(serialized.Name)
+	if err != nil {
+		return nil, err
+	}
+	if len(serialized.Params) > 0 {
+		if err := json.Unmarshal(serialized.Params, optimizer); err != nil {
+			return nil, err
+		}
+	}
+	if err := validateOptimizer(optimizer); err != nil {
+		return nil, err
+	}
+	return optimizer, nil
+}
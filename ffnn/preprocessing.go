@@ -0,0 +1,529 @@
+package ffnn
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// A preprocessing step transforms raw feature columns before
+//   they reach the first layer. Steps are fitted once (e.g. on
+//   training data) and their fitted parameters are saved with
+//   the network, so they MUST be JSON-marshalable structs with
+//   exported fields, and registered by name.
+type Preprocessor interface {
+	// Step name (key)
+	Name() string
+	// Learns the parameters from (size, 1) sample columns
+	Fit(samples []*mat.Dense) error
+	// Whether the step can already transform columns
+	Fitted() bool
+	// The size of the transformed columns
+	OutputSize(inputSize int) int
+	// Transforms a column into a new one
+	Transform(x *mat.Dense) *mat.Dense
+}
+
+// Implemented by the steps whose fitted parameters depend on the
+//   size of their inputs, so the ones loaded from a file can be
+//   checked before they transform anything
+type SizedPreprocessor interface {
+	Preprocessor
+	// Whether the parameters fit inputs of the given size
+	CheckInputSize(inputSize int) error
+}
+
+// A sequence of steps, applied in order, to inputs of a fixed size
+type Preprocessing struct {
+	inputSize int
+	steps     []Preprocessor
+}
+
+func NewPreprocessing(inputSize int, steps ...Preprocessor) *Preprocessing {
+	if inputSize < 1 {
+		panic("input size must be >= 1")
+	}
+	return &Preprocessing{inputSize: inputSize, steps: steps}
+}
+
+func (preprocessing *Preprocessing) InputSize() int {
+	return preprocessing.inputSize
+}
+
+func (preprocessing *Preprocessing) OutputSize() int {
+	size := preprocessing.inputSize
+	for _, step := range preprocessing.steps {
+		size = step.OutputSize(size)
+	}
+	return size
+}
+
+func (preprocessing *Preprocessing) Steps() []Preprocessor {
+	return preprocessing.steps
+}
+
+func (preprocessing *Preprocessing) Fitted() bool {
+	for _, step := range preprocessing.steps {
+		if !step.Fitted() {
+			return false
+		}
+	}
+	return true
+}
+
+// Fits the steps not fitted yet, in order, each one over the
+//   samples as transformed by the previous steps.
+func (preprocessing *Preprocessing) Fit(samples []*mat.Dense) error {
+	if len(samples) == 0 {
+		return errors.New("at least one sample is needed to fit the preprocessing")
+	}
+	for _, sample := range samples {
+		if rows, _ := sample.Dims(); rows != preprocessing.inputSize {
+			return errors.New(fmt.Sprintf(
+				"sample size %v does not match preprocessing input size %v", rows, preprocessing.inputSize,
+			))
+		}
+	}
+	for index, step := range preprocessing.steps {
+		if !step.Fitted() {
+			if err := step.Fit(samples); err != nil {
+				return err
+			}
+		}
+		if index < len(preprocessing.steps)-1 {
+			transformed := make([]*mat.Dense, len(samples))
+			for sampleIndex, sample := range samples {
+				transformed[sampleIndex] = step.Transform(sample)
+			}
+			samples = transformed
+		}
+	}
+	return nil
+}
+
+func (preprocessing *Preprocessing) Transform(x *mat.Dense) *mat.Dense {
+	for _, step := range preprocessing.steps {
+		x = step.Transform(x)
+	}
+	return x
+}
+
+// Min-max scaling of each feature into [Low, High]. When Min
+//   and Max have a single element, it is used for all features.
+type MinMaxScaler struct {
+	Low  float64
+	High float64
+	Min  []float64
+	Max  []float64
+}
+
+func NewMinMaxScaler(low, high float64) *MinMaxScaler {
+	return &MinMaxScaler{Low: low, High: high}
+}
+
+// A scaler that needs no fitting, since the range of all the
+//   features is known in advance (e.g. [0, 255] for pixels).
+func NewFixedMinMaxScaler(min, max, low, high float64) *MinMaxScaler {
+	return &MinMaxScaler{Low: low, High: high, Min: []float64{min}, Max: []float64{max}}
+}
+
+func (s *MinMaxScaler) Name() string {
+	return "MinMaxScaler"
+}
+
+func (s *MinMaxScaler) Fit(samples []*mat.Dense) error {
+	size, _ := samples[0].Dims()
+	s.Min = make([]float64, size)
+	s.Max = make([]float64, size)
+	for i := 0; i < size; i++ {
+		s.Min[i], s.Max[i] = math.Inf(1), math.Inf(-1)
+	}
+	for _, sample := range samples {
+		for i := 0; i < size; i++ {
+			s.Min[i] = math.Min(s.Min[i], sample.At(i, 0))
+			s.Max[i] = math.Max(s.Max[i], sample.At(i, 0))
+		}
+	}
+	return nil
+}
+
+func (s *MinMaxScaler) Fitted() bool {
+	return len(s.Min) > 0 && len(s.Min) == len(s.Max)
+}
+
+func (s *MinMaxScaler) CheckInputSize(inputSize int) error {
+	if len(s.Min) != 1 && len(s.Min) != inputSize {
+		return errors.New(fmt.Sprintf("MinMaxScaler has %v ranges for %v features", len(s.Min), inputSize))
+	}
+	return nil
+}
+
+func (s *MinMaxScaler) OutputSize(inputSize int) int {
+	return inputSize
+}
+
+func (s *MinMaxScaler) Transform(x *mat.Dense) *mat.Dense {
+	rows, _ := x.Dims()
+	result := mat.NewDense(rows, 1, nil)
+	for i := 0; i < rows; i++ {
+		min, max := broadcast(s.Min, i), broadcast(s.Max, i)
+		scaled := 0.0
+		if max > min {
+			scaled = (x.At(i, 0) - min) / (max - min)
+		}
+		result.Set(i, 0, s.Low+scaled*(s.High-s.Low))
+	}
+	return result
+}
+
+// Standardization of each feature to zero mean, unit variance
+type Standardizer struct {
+	Mean []float64
+	Std  []float64
+}
+
+func NewStandardizer() *Standardizer {
+	return &Standardizer{}
+}
+
+func (s *Standardizer) Name() string {
+	return "Standardizer"
+}
+
+func (s *Standardizer) Fit(samples []*mat.Dense) error {
+	size, _ := samples[0].Dims()
+	s.Mean, s.Std = make([]float64, size), make([]float64, size)
+	count := float64(len(samples))
+	for _, sample := range samples {
+		for i := 0; i < size; i++ {
+			s.Mean[i] += sample.At(i, 0) / count
+		}
+	}
+	for _, sample := range samples {
+		for i := 0; i < size; i++ {
+			d := sample.At(i, 0) - s.Mean[i]
+			s.Std[i] += d * d / count
+		}
+	}
+	for i := range s.Std {
+		// Constant features are only centered
+		if s.Std[i] = math.Sqrt(s.Std[i]); s.Std[i] == 0 {
+			s.Std[i] = 1
+		}
+	}
+	return nil
+}
+
+func (s *Standardizer) Fitted() bool {
+	return len(s.Mean) > 0 && len(s.Mean) == len(s.Std)
+}
+
+func (s *Standardizer) CheckInputSize(inputSize int) error {
+	if len(s.Mean) != inputSize {
+		return errors.New(fmt.Sprintf("Standardizer has %v means for %v features", len(s.Mean), inputSize))
+	}
+	return nil
+}
+
+func (s *Standardizer) OutputSize(inputSize int) int {
+	return inputSize
+}
+
+func (s *Standardizer) Transform(x *mat.Dense) *mat.Dense {
+	rows, _ := x.Dims()
+	result := mat.NewDense(rows, 1, nil)
+	for i := 0; i < rows; i++ {
+		result.Set(i, 0, (x.At(i, 0)-s.Mean[i])/s.Std[i])
+	}
+	return result
+}
+
+// PCA projection into the Components principal directions, each
+//   one scaled by 1/sqrt(eigenvalue + Epsilon) (i.e. whitened).
+type PCAWhitening struct {
+	Components int
+	Epsilon    float64
+	Mean       []float64
+	// Size: (Components rows, inputSize columns), row-major
+	Projection []float64
+}
+
+func NewPCAWhitening(components int, epsilon float64) *PCAWhitening {
+	if components < 1 {
+		panic("components must be >= 1")
+	}
+	return &PCAWhitening{Components: components, Epsilon: epsilon}
+}
+
+func (p *PCAWhitening) Name() string {
+	return "PCAWhitening"
+}
+
+func (p *PCAWhitening) Fit(samples []*mat.Dense) error {
+	size, _ := samples[0].Dims()
+	if p.Components > size {
+		return errors.New(fmt.Sprintf("cannot keep %v components out of %v features", p.Components, size))
+	}
+	count := float64(len(samples))
+	p.Mean = make([]float64, size)
+	for _, sample := range samples {
+		for i := 0; i < size; i++ {
+			p.Mean[i] += sample.At(i, 0) / count
+		}
+	}
+	covariance := mat.NewSymDense(size, nil)
+	centered := make([]float64, size)
+	for _, sample := range samples {
+		for i := 0; i < size; i++ {
+			centered[i] = sample.At(i, 0) - p.Mean[i]
+		}
+		covariance.SymRankOne(covariance, 1/count, mat.NewVecDense(size, centered))
+	}
+
+	var eigen mat.EigenSym
+	if !eigen.Factorize(covariance, true) {
+		return errors.New("the covariance eigendecomposition did not converge")
+	}
+	values := eigen.Values(nil)
+	var vectors mat.Dense
+	eigen.VectorsTo(&vectors)
+
+	// Eigenvalues come in ascending order, so the principal
+	//   components are the last ones
+	p.Projection = make([]float64, p.Components*size)
+	for k := 0; k < p.Components; k++ {
+		column := size - 1 - k
+		scale := 1 / math.Sqrt(math.Max(values[column], 0)+p.Epsilon)
+		for i := 0; i < size; i++ {
+			p.Projection[k*size+i] = vectors.At(i, column) * scale
+		}
+	}
+	return nil
+}
+
+func (p *PCAWhitening) Fitted() bool {
+	return len(p.Mean) > 0 && len(p.Projection) == p.Components*len(p.Mean)
+}
+
+func (p *PCAWhitening) CheckInputSize(inputSize int) error {
+	if len(p.Mean) != inputSize {
+		return errors.New(fmt.Sprintf("PCAWhitening has %v means for %v features", len(p.Mean), inputSize))
+	}
+	if p.Components < 1 {
+		return errors.New("PCAWhitening components must be >= 1")
+	}
+	return nil
+}
+
+func (p *PCAWhitening) OutputSize(inputSize int) int {
+	return p.Components
+}
+
+func (p *PCAWhitening) Transform(x *mat.Dense) *mat.Dense {
+	size := len(p.Mean)
+	centered := mat.NewDense(size, 1, nil)
+	for i := 0; i < size; i++ {
+		centered.Set(i, 0, x.At(i, 0)-p.Mean[i])
+	}
+	result := mat.NewDense(p.Components, 1, nil)
+	result.Product(mat.NewDense(p.Components, size, p.Projection), centered)
+	return result
+}
+
+// Replaces the categorical feature at Index by a one-hot block
+//   of its known Categories (in the same position). Unknown
+//   categories produce an all-zeros block.
+type OneHot struct {
+	Index      int
+	Categories []float64
+}
+
+func NewOneHot(index int) *OneHot {
+	return &OneHot{Index: index}
+}
+
+func (o *OneHot) Name() string {
+	return "OneHot"
+}
+
+func (o *OneHot) Fit(samples []*mat.Dense) error {
+	if size, _ := samples[0].Dims(); o.Index < 0 || o.Index >= size {
+		return errors.New(fmt.Sprintf("one-hot feature index %v out of range", o.Index))
+	}
+	seen := map[float64]bool{}
+	o.Categories = make([]float64, 0)
+	for _, sample := range samples {
+		if value := sample.At(o.Index, 0); !seen[value] {
+			seen[value] = true
+			o.Categories = append(o.Categories, value)
+		}
+	}
+	sort.Float64s(o.Categories)
+	return nil
+}
+
+func (o *OneHot) Fitted() bool {
+	return len(o.Categories) > 0
+}
+
+func (o *OneHot) CheckInputSize(inputSize int) error {
+	if o.Index < 0 || o.Index >= inputSize {
+		return errors.New(fmt.Sprintf("one-hot feature index %v out of range", o.Index))
+	}
+	return nil
+}
+
+func (o *OneHot) OutputSize(inputSize int) int {
+	return inputSize - 1 + len(o.Categories)
+}
+
+func (o *OneHot) Transform(x *mat.Dense) *mat.Dense {
+	rows, _ := x.Dims()
+	result := mat.NewDense(o.OutputSize(rows), 1, nil)
+	for i := 0; i < o.Index; i++ {
+		result.Set(i, 0, x.At(i, 0))
+	}
+	value := x.At(o.Index, 0)
+	for k, category := range o.Categories {
+		if category == value {
+			result.Set(o.Index+k, 0, 1)
+		}
+	}
+	for i := o.Index + 1; i < rows; i++ {
+		result.Set(i-1+len(o.Categories), 0, x.At(i, 0))
+	}
+	return result
+}
+
+// Clips all the features into [Min, Max]. Needs no fitting.
+type Clipping struct {
+	Min float64
+	Max float64
+}
+
+func NewClipping(min, max float64) *Clipping {
+	return &Clipping{Min: min, Max: max}
+}
+
+func (c *Clipping) Name() string {
+	return "Clipping"
+}
+
+func (c *Clipping) Fit(samples []*mat.Dense) error {
+	return nil
+}
+
+func (c *Clipping) Fitted() bool {
+	return true
+}
+
+func (c *Clipping) OutputSize(inputSize int) int {
+	return inputSize
+}
+
+func (c *Clipping) Transform(x *mat.Dense) *mat.Dense {
+	rows, _ := x.Dims()
+	result := mat.NewDense(rows, 1, nil)
+	result.Apply(func(i, j int, v float64) float64 {
+		return math.Max(c.Min, math.Min(c.Max, v))
+	}, x)
+	return result
+}
+
+func broadcast(values []float64, index int) float64 {
+	if len(values) == 1 {
+		return values[0]
+	}
+	return values[index]
+}
+
+var preprocessors = newRegistry("preprocessing step", map[string]interface{}{
+	"MinMaxScaler": func() Preprocessor { return &MinMaxScaler{} },
+	"Standardizer": func() Preprocessor { return &Standardizer{} },
+	"PCAWhitening": func() Preprocessor { return &PCAWhitening{} },
+	"OneHot":       func() Preprocessor { return &OneHot{} },
+	"Clipping":     func() Preprocessor { return &Clipping{} },
+})
+
+// Registers a factory of empty steps, which will be filled by
+//   unmarshaling the saved parameters when loading a network.
+func RegisterPreprocessor(name string, factory func() Preprocessor) bool {
+	if factory == nil {
+		return false
+	}
+	return preprocessors.register(name, factory)
+}
+
+// The registered preprocessing step names, sorted
+func PreprocessorNames() []string {
+	return preprocessors.names()
+}
+
+type serializedPreprocessor struct {
+	Name   string
+	Params json.RawMessage
+}
+type serializedPreprocessing struct {
+	InputSize int
+	Steps     []*serializedPreprocessor
+}
+
+func encodePreprocessing(preprocessing *Preprocessing) (*serializedPreprocessing, error) {
+	if preprocessing == nil {
+		return nil, nil
+	}
+	serialized := &serializedPreprocessing{
+		InputSize: preprocessing.inputSize,
+		Steps:     make([]*serializedPreprocessor, len(preprocessing.steps)),
+	}
+	for index, step := range preprocessing.steps {
+		if params, err := json.Marshal(step); err != nil {
+			return nil, err
+		} else {
+			serialized.Steps[index] = &serializedPreprocessor{Name: step.Name(), Params: params}
+		}
+	}
+	return serialized, nil
+}
+
+func decodePreprocessing(serialized *serializedPreprocessing) (*Preprocessing, error) {
+	if serialized == nil {
+		return nil, nil
+	}
+	if serialized.InputSize < 1 {
+		return nil, errors.New("preprocessing input size must be >= 1")
+	}
+	preprocessing := &Preprocessing{
+		inputSize: serialized.InputSize,
+		steps:     make([]Preprocessor, len(serialized.Steps)),
+	}
+	// The size of the inputs of each step
+	size := serialized.InputSize
+	for index, serializedStep := range serialized.Steps {
+		factory, err := preprocessors.lookup(serializedStep.Name)
+		if err != nil {
+			return nil, err
+		}
+		step := factory.(func() Preprocessor)()
+		if err := json.Unmarshal(serializedStep.Params, step); err != nil {
+			return nil, err
+		}
+		if !step.Fitted() {
+			return nil, errors.New(fmt.Sprintf("preprocessing step %v was saved unfitted", serializedStep.Name))
+		}
+		if sized, ok := step.(SizedPreprocessor); ok {
+			if err := sized.CheckInputSize(size); err != nil {
+				return nil, fmt.Errorf("preprocessing step %v: %w", index, err)
+			}
+		}
+		if size = step.OutputSize(size); size < 1 {
+			return nil, errors.New(fmt.Sprintf("preprocessing step %v outputs no features", index))
+		}
+		preprocessing.steps[index] = step
+	}
+	return preprocessing, nil
+}
@@ -0,0 +1,95 @@
+package ffnn
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Matches (with errors.Is) any UnknownNameError
+var ErrUnknownName = errors.New("unknown name")
+
+// A name (e.g. in a saved network, or a config) that is not
+//   registered in this program
+type UnknownNameError struct {
+	// What was looked up, e.g. "activator"
+	Kind string
+	Name string
+	// The names registered at the time of the lookup
+	Known []string
+}
+
+func (err *UnknownNameError) Error() string {
+	return fmt.Sprintf("unknown %v %q (registered: %v)", err.Kind, err.Name, strings.Join(err.Known, ", "))
+}
+
+func (err *UnknownNameError) Is(target error) bool {
+	return target == ErrUnknownName
+}
+
+// Named components of a kind (activators, optimizer factories...),
+//   safe for concurrent registration and lookup. The "_default"
+//   entry, when present, is not listed among the names, nor found
+//   by the strict lookups.
+type registry struct {
+	kind    string
+	mutex   sync.RWMutex
+	entries map[string]interface{}
+}
+
+const defaultEntry = "_default"
+
+func newRegistry(kind string, entries map[string]interface{}) *registry {
+	return &registry{kind: kind, entries: entries}
+}
+
+// Adds the entry, unless the name is already taken (or reserved)
+func (r *registry) register(name string, entry interface{}) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, found := r.entries[name]; found || name == defaultEntry {
+		return false
+	}
+	r.entries[name] = entry
+	return true
+}
+
+// Strict lookup: unknown names are errors
+func (r *registry) lookup(name string) (interface{}, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	if entry, found := r.entries[name]; found && name != defaultEntry {
+		return entry, nil
+	}
+	return nil, &UnknownNameError{Kind: r.kind, Name: name, Known: r.sortedNames()}
+}
+
+// Lenient lookup: unknown names fall back to the default entry
+func (r *registry) get(name string) interface{} {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	if entry, found := r.entries[name]; found {
+		return entry
+	}
+	return r.entries[defaultEntry]
+}
+
+func (r *registry) names() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.sortedNames()
+}
+
+// MUST be called with the mutex held
+func (r *registry) sortedNames() []string {
+	names := make([]string, 0, len(r.entries))
+	for name := range r.entries {
+		if name != defaultEntry {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
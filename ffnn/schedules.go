@@ -0,0 +1,147 @@
+package ffnn
+
+import (
+	"encoding/json"
+	"math"
+)
+
+// A learning rate schedule: the rate to use in an epoch (0-based)
+//   given the base rate. Since schedules are saved with the
+//   training checkpoints (along with the epoch, which is their
+//   position), they MUST be JSON-marshalable structs with exported
+//   fields, and registered by name.
+type Schedule interface {
+	// Schedule name (key)
+	Name() string
+	Rate(baseRate float64, epoch int) float64
+}
+
+
+// The base rate, always
+type ConstantSchedule struct{}
+func (c *ConstantSchedule) Name() string {
+	return "Constant"
+}
+func (c *ConstantSchedule) Rate(baseRate float64, epoch int) float64 {
+	return baseRate
+}
+
+
+// The rate multiplied by Factor every Every epochs
+type StepDecay struct {
+	Every  int
+	Factor float64
+}
+func NewStepDecay(every int, factor float64) *StepDecay {
+	return &StepDecay{Every: every, Factor: factor}
+}
+func (s *StepDecay) Name() string {
+	return "StepDecay"
+}
+func (s *StepDecay) Rate(baseRate float64, epoch int) float64 {
+	if s.Every < 1 {
+		return baseRate
+	}
+	return baseRate * math.Pow(s.Factor, float64(epoch / s.Every))
+}
+
+
+// The rate multiplied by Gamma every epoch
+type ExponentialDecay struct {
+	Gamma float64
+}
+func NewExponentialDecay(gamma float64) *ExponentialDecay {
+	return &ExponentialDecay{Gamma: gamma}
+}
+func (e *ExponentialDecay) Name() string {
+	return "ExponentialDecay"
+}
+func (e *ExponentialDecay) Rate(baseRate float64, epoch int) float64 {
+	return baseRate * math.Pow(e.Gamma, float64(epoch))
+}
+
+
+// The rate going from the base one to MinRate along Epochs epochs,
+//   following half a cosine, and staying at MinRate afterwards
+type CosineDecay struct {
+	Epochs  int
+	MinRate float64
+}
+func NewCosineDecay(epochs int, minRate float64) *CosineDecay {
+	return &CosineDecay{Epochs: epochs, MinRate: minRate}
+}
+func (c *CosineDecay) Name() string {
+	return "CosineDecay"
+}
+func (c *CosineDecay) Rate(baseRate float64, epoch int) float64 {
+	if c.Epochs < 1 || epoch >= c.Epochs {
+		return c.MinRate
+	}
+	progress := float64(epoch) / float64(c.Epochs)
+	return c.MinRate + (baseRate - c.MinRate) * (1 + math.Cos(math.Pi * progress)) / 2
+}
+
+
+// Factories of schedules with their default parameters
+var schedules = newRegistry("schedule", map[string]interface{}{
+	"_default": func() Schedule { return &ConstantSchedule{} },
+	"Constant": func() Schedule { return &ConstantSchedule{} },
+	"StepDecay": func() Schedule { return NewStepDecay(10, 0.5) },
+	"ExponentialDecay": func() Schedule { return NewExponentialDecay(0.95) },
+	"CosineDecay": func() Schedule { return NewCosineDecay(10, 0) },
+})
+
+func RegisterSchedule(name string, factory func() Schedule) bool {
+	if factory == nil {
+		return false
+	}
+	return schedules.register(name, factory)
+}
+
+// Creates a schedule with its default parameters. Fails with an
+//   UnknownNameError for unknown names.
+func NewSchedule(name string) (Schedule, error) {
+	if factory, err := schedules.lookup(name); err != nil {
+		return nil, err
+	} else {
+		return factory.(func() Schedule)(), nil
+	}
+}
+
+// The registered schedule names, sorted
+func ScheduleNames() []string {
+	return schedules.names()
+}
+
+
+type serializedSchedule struct {
+	Name   string
+	Params json.RawMessage
+}
+
+func encodeSchedule(schedule Schedule) (*serializedSchedule, error) {
+	if schedule == nil {
+		return nil, nil
+	}
+	if params, err := json.Marshal(schedule); err != nil {
+		return nil, err
+	} else {
+		return &serializedSchedule{Name: schedule.Name(), Params: params}, nil
+	}
+}
+
+func decodeSchedule(serialized *serializedSchedule) (Schedule, error) {
+	if serialized == nil {
+		return nil, nil
+	}
+	schedule, err := NewSchedule(serialized.Name)
+	if err != nil {
+		return nil, err
+	}
+	if len(serialized.Params) > 0 {
+		if err := json.Unmarshal(serialized.Params, schedule); err != nil {
+			return nil, err
+		}
+	}
+	return schedule, nil
+}
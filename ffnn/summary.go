@@ -0,0 +1,138 @@
+package ffnn
+
+import (
+	"encoding/json"
+	"gonum.org/v1/gonum/mat"
+	"../metrics"
+	"math"
+)
+
+// Descriptive statistics of the values of a parameter matrix
+type Stats struct {
+	Count int     `json:"count"`
+	Mean  float64 `json:"mean"`
+	Std   float64 `json:"std"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	// The fraction of the values that are exactly zero
+	Sparsity float64 `json:"sparsity"`
+	// The sum of the absolute values, and the Frobenius norm
+	L1Norm float64 `json:"l1_norm"`
+	L2Norm float64 `json:"l2_norm"`
+}
+
+// Encodes the non-finite values (as in diverged networks) as null
+func (stats Stats) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Count    int            `json:"count"`
+		Mean     metrics.Number `json:"mean"`
+		Std      metrics.Number `json:"std"`
+		Min      metrics.Number `json:"min"`
+		Max      metrics.Number `json:"max"`
+		Sparsity float64        `json:"sparsity"`
+		L1Norm   metrics.Number `json:"l1_norm"`
+		L2Norm   metrics.Number `json:"l2_norm"`
+	}{
+		stats.Count, metrics.Number(stats.Mean), metrics.Number(stats.Std), metrics.Number(stats.Min),
+		metrics.Number(stats.Max), stats.Sparsity, metrics.Number(stats.L1Norm), metrics.Number(stats.L2Norm),
+	})
+}
+
+func MatrixStats(matrix mat.Matrix) Stats {
+	rows, columns := matrix.Dims()
+	stats := Stats{Count: rows * columns}
+	if stats.Count == 0 {
+		return stats
+	}
+	stats.Min, stats.Max = math.Inf(1), math.Inf(-1)
+	sum, squares, zeros := 0.0, 0.0, 0
+	for row := 0; row < rows; row++ {
+		for column := 0; column < columns; column++ {
+			value := matrix.At(row, column)
+			sum += value
+			squares += value * value
+			stats.L1Norm += math.Abs(value)
+			stats.Min, stats.Max = math.Min(stats.Min, value), math.Max(stats.Max, value)
+			if value == 0 {
+				zeros++
+			}
+		}
+	}
+	count := float64(stats.Count)
+	stats.Mean = sum / count
+	stats.Std = math.Sqrt(math.Max(squares / count - stats.Mean * stats.Mean, 0))
+	stats.Sparsity = float64(zeros) / count
+	stats.L2Norm = math.Sqrt(squares)
+	return stats
+}
+
+
+type LayerSummary struct {
+	InputSize  int    `json:"input_size"`
+	OutputSize int    `json:"output_size"`
+	Activator  string `json:"activator"`
+	// The weights, biases and learnable activator parameters
+	Parameters int   `json:"parameters"`
+	Weights    Stats `json:"weights"`
+	Biases     Stats `json:"biases"`
+}
+
+// A description of the network: its architecture, parameters,
+//   training settings and provenance
+type Summary struct {
+	InputSize     int            `json:"input_size"`
+	OutputSize    int            `json:"output_size"`
+	Preprocessing []string       `json:"preprocessing,omitempty"`
+	Layers        []LayerSummary `json:"layers"`
+	TargetScaling []string       `json:"target_scaling,omitempty"`
+	Parameters    int            `json:"parameters"`
+	// The name of the error metric it is trained against
+	Loss                string    `json:"loss"`
+	Optimizer           string    `json:"optimizer"`
+	L1                  float64   `json:"l1,omitempty"`
+	L2                  float64   `json:"l2,omitempty"`
+	DefaultLearningRate float64   `json:"default_learning_rate"`
+	Checksum            string    `json:"checksum,omitempty"`
+	Metadata            *Metadata `json:"metadata,omitempty"`
+}
+
+func (network *FFNetwork) Summary() *Summary {
+	summary := &Summary{
+		InputSize:           network.InputSize(),
+		OutputSize:          network.layers[len(network.layers) - 1].outputSize,
+		Loss:                network.c.Name(),
+		Optimizer:           network.optimizer.Name(),
+		L1:                  network.l1,
+		L2:                  network.l2,
+		DefaultLearningRate: network.defaultLearningRate,
+		Checksum:            network.checksum,
+		Metadata:            network.metadata,
+	}
+	if network.preprocessing != nil {
+		for _, step := range network.preprocessing.Steps() {
+			summary.Preprocessing = append(summary.Preprocessing, step.Name())
+		}
+	}
+	for _, layer := range network.layers {
+		layerSummary := LayerSummary{
+			InputSize:  layer.inputSize,
+			OutputSize: layer.outputSize,
+			Activator:  layer.f.Name(),
+			Weights:    MatrixStats(layer.w),
+			Biases:     MatrixStats(layer.b),
+		}
+		layerSummary.Parameters = layerSummary.Weights.Count + layerSummary.Biases.Count
+		for _, parameter := range activatorParameters(layer.f) {
+			rows, columns := parameter.Dims()
+			layerSummary.Parameters += rows * columns
+		}
+		summary.Layers = append(summary.Layers, layerSummary)
+		summary.Parameters += layerSummary.Parameters
+	}
+	if network.targetScaling != nil {
+		for _, scaler := range network.targetScaling.Scalers() {
+			summary.TargetScaling = append(summary.TargetScaling, scaler.Name())
+		}
+	}
+	return summary
+}
@@ -0,0 +1,338 @@
+package ffnn
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// A target scaler maps targets in their original units into the
+//   units the network is trained on (e.g. the range of a sigmoid
+//   output), and the network outputs back. Like the preprocessors,
+//   they are saved with the network, so they MUST be JSON-marshalable
+//   structs with exported fields, and registered by name.
+type TargetScaler interface {
+	// Scaler name (key)
+	Name() string
+	// Learns the parameters from (size, 1) target columns
+	Fit(targets []*mat.Dense) error
+	// Whether the scaler can already transform columns
+	Fitted() bool
+	// Maps a column of original units into a new column
+	Transform(t *mat.Dense) *mat.Dense
+	// Maps a column of network units back into a new column
+	Inverse(y *mat.Dense) *mat.Dense
+}
+
+// Implemented by the scalers whose fitted parameters depend on the
+//   size of the targets, so the ones loaded from a file can be
+//   checked before they transform anything
+type SizedTargetScaler interface {
+	TargetScaler
+	// Whether the parameters are valid for targets of the given size
+	CheckOutputSize(outputSize int) error
+}
+
+// A sequence of scalers applied in order to the targets, and in
+//   reverse order to the outputs.
+type TargetScaling struct {
+	outputSize int
+	scalers    []TargetScaler
+}
+
+func NewTargetScaling(outputSize int, scalers ...TargetScaler) *TargetScaling {
+	if outputSize < 1 {
+		panic("output size must be >= 1")
+	}
+	return &TargetScaling{outputSize: outputSize, scalers: scalers}
+}
+
+func (scaling *TargetScaling) OutputSize() int {
+	return scaling.outputSize
+}
+
+func (scaling *TargetScaling) Scalers() []TargetScaler {
+	return scaling.scalers
+}
+
+func (scaling *TargetScaling) Fitted() bool {
+	for _, scaler := range scaling.scalers {
+		if !scaler.Fitted() {
+			return false
+		}
+	}
+	return true
+}
+
+// Fits the scalers not fitted yet, in order, each one over the
+//   targets as transformed by the previous scalers.
+func (scaling *TargetScaling) Fit(targets []*mat.Dense) error {
+	if len(targets) == 0 {
+		return errors.New("at least one target is needed to fit the target scaling")
+	}
+	for _, target := range targets {
+		if rows, _ := target.Dims(); rows != scaling.outputSize {
+			return errors.New(fmt.Sprintf(
+				"target size %v does not match target scaling output size %v", rows, scaling.outputSize,
+			))
+		}
+	}
+	for _, scaler := range scaling.scalers {
+		if !scaler.Fitted() {
+			if err := scaler.Fit(targets); err != nil {
+				return err
+			}
+		}
+		transformed := make([]*mat.Dense, len(targets))
+		for index, target := range targets {
+			transformed[index] = scaler.Transform(target)
+		}
+		targets = transformed
+	}
+	return nil
+}
+
+func (scaling *TargetScaling) Transform(t *mat.Dense) *mat.Dense {
+	for _, scaler := range scaling.scalers {
+		t = scaler.Transform(t)
+	}
+	return t
+}
+
+func (scaling *TargetScaling) Inverse(y *mat.Dense) *mat.Dense {
+	for index := len(scaling.scalers) - 1; index >= 0; index-- {
+		y = scaling.scalers[index].Inverse(y)
+	}
+	return y
+}
+
+// Min-max scaling of each target into [Low, High]. The default
+//   range, [0.01, 0.99], suits sigmoid outputs.
+type MinMaxTargetScaler struct {
+	Low  float64
+	High float64
+	Min  []float64
+	Max  []float64
+}
+
+func NewMinMaxTargetScaler(low, high float64) *MinMaxTargetScaler {
+	if low == 0 && high == 0 {
+		low, high = 0.01, 0.99
+	}
+	if low == high {
+		panic("the target range must not be empty")
+	}
+	return &MinMaxTargetScaler{Low: low, High: high}
+}
+
+func (s *MinMaxTargetScaler) Name() string {
+	return "MinMaxTargetScaler"
+}
+
+func (s *MinMaxTargetScaler) Fit(targets []*mat.Dense) error {
+	scaler := &MinMaxScaler{}
+	scaler.Fit(targets)
+	s.Min, s.Max = scaler.Min, scaler.Max
+	return nil
+}
+
+func (s *MinMaxTargetScaler) Fitted() bool {
+	return len(s.Min) > 0 && len(s.Min) == len(s.Max)
+}
+
+func (s *MinMaxTargetScaler) CheckOutputSize(outputSize int) error {
+	if s.Low == s.High {
+		return errors.New("MinMaxTargetScaler range is empty")
+	}
+	if len(s.Min) != 1 && len(s.Min) != outputSize {
+		return errors.New(fmt.Sprintf("MinMaxTargetScaler has %v ranges for %v targets", len(s.Min), outputSize))
+	}
+	return nil
+}
+
+func (s *MinMaxTargetScaler) Transform(t *mat.Dense) *mat.Dense {
+	return (&MinMaxScaler{Low: s.Low, High: s.High, Min: s.Min, Max: s.Max}).Transform(t)
+}
+
+func (s *MinMaxTargetScaler) Inverse(y *mat.Dense) *mat.Dense {
+	rows, _ := y.Dims()
+	result := mat.NewDense(rows, 1, nil)
+	for i := 0; i < rows; i++ {
+		min, max := broadcast(s.Min, i), broadcast(s.Max, i)
+		result.Set(i, 0, min+(y.At(i, 0)-s.Low)/(s.High-s.Low)*(max-min))
+	}
+	return result
+}
+
+// Standardization of each target to zero mean, unit variance
+type StandardTargetScaler struct {
+	Mean []float64
+	Std  []float64
+}
+
+func NewStandardTargetScaler() *StandardTargetScaler {
+	return &StandardTargetScaler{}
+}
+
+func (s *StandardTargetScaler) Name() string {
+	return "StandardTargetScaler"
+}
+
+func (s *StandardTargetScaler) Fit(targets []*mat.Dense) error {
+	standardizer := &Standardizer{}
+	standardizer.Fit(targets)
+	s.Mean, s.Std = standardizer.Mean, standardizer.Std
+	return nil
+}
+
+func (s *StandardTargetScaler) Fitted() bool {
+	return len(s.Mean) > 0 && len(s.Mean) == len(s.Std)
+}
+
+func (s *StandardTargetScaler) CheckOutputSize(outputSize int) error {
+	if len(s.Mean) != outputSize {
+		return errors.New(fmt.Sprintf("StandardTargetScaler has %v means for %v targets", len(s.Mean), outputSize))
+	}
+	return nil
+}
+
+func (s *StandardTargetScaler) Transform(t *mat.Dense) *mat.Dense {
+	return (&Standardizer{Mean: s.Mean, Std: s.Std}).Transform(t)
+}
+
+func (s *StandardTargetScaler) Inverse(y *mat.Dense) *mat.Dense {
+	rows, _ := y.Dims()
+	result := mat.NewDense(rows, 1, nil)
+	for i := 0; i < rows; i++ {
+		result.Set(i, 0, y.At(i, 0)*s.Std[i]+s.Mean[i])
+	}
+	return result
+}
+
+// Maps targets into log(t + Offset). Usually followed by another
+//   scaler, since the result is not bounded.
+type LogTargetScaler struct {
+	Offset float64
+}
+
+func NewLogTargetScaler(offset float64) *LogTargetScaler {
+	return &LogTargetScaler{Offset: offset}
+}
+
+func (s *LogTargetScaler) Name() string {
+	return "LogTargetScaler"
+}
+
+func (s *LogTargetScaler) Fit(targets []*mat.Dense) error {
+	for _, target := range targets {
+		if mat.Min(target)+s.Offset <= 0 {
+			return errors.New("log target scaling requires targets greater than -offset")
+		}
+	}
+	return nil
+}
+
+func (s *LogTargetScaler) Fitted() bool {
+	return true
+}
+
+func (s *LogTargetScaler) Transform(t *mat.Dense) *mat.Dense {
+	rows, _ := t.Dims()
+	result := mat.NewDense(rows, 1, nil)
+	result.Apply(func(i, j int, v float64) float64 {
+		return math.Log(v + s.Offset)
+	}, t)
+	return result
+}
+
+func (s *LogTargetScaler) Inverse(y *mat.Dense) *mat.Dense {
+	rows, _ := y.Dims()
+	result := mat.NewDense(rows, 1, nil)
+	result.Apply(func(i, j int, v float64) float64 {
+		return math.Exp(v) - s.Offset
+	}, y)
+	return result
+}
+
+var targetScalers = newRegistry("target scaler", map[string]interface{}{
+	"MinMaxTargetScaler":   func() TargetScaler { return &MinMaxTargetScaler{} },
+	"StandardTargetScaler": func() TargetScaler { return &StandardTargetScaler{} },
+	"LogTargetScaler":      func() TargetScaler { return &LogTargetScaler{} },
+})
+
+// Registers a factory of empty scalers, which will be filled by
+//   unmarshaling the saved parameters when loading a network.
+func RegisterTargetScaler(name string, factory func() TargetScaler) bool {
+	if factory == nil {
+		return false
+	}
+	return targetScalers.register(name, factory)
+}
+
+// The registered target scaler names, sorted
+func TargetScalerNames() []string {
+	return targetScalers.names()
+}
+
+type serializedTargetScaler struct {
+	Name   string
+	Params json.RawMessage
+}
+type serializedTargetScaling struct {
+	OutputSize int
+	Scalers    []*serializedTargetScaler
+}
+
+func encodeTargetScaling(scaling *TargetScaling) (*serializedTargetScaling, error) {
+	if scaling == nil {
+		return nil, nil
+	}
+	serialized := &serializedTargetScaling{
+		OutputSize: scaling.outputSize,
+		Scalers:    make([]*serializedTargetScaler, len(scaling.scalers)),
+	}
+	for index, scaler := range scaling.scalers {
+		if params, err := json.Marshal(scaler); err != nil {
+			return nil, err
+		} else {
+			serialized.Scalers[index] = &serializedTargetScaler{Name: scaler.Name(), Params: params}
+		}
+	}
+	return serialized, nil
+}
+
+func decodeTargetScaling(serialized *serializedTargetScaling) (*TargetScaling, error) {
+	if serialized == nil {
+		return nil, nil
+	}
+	if serialized.OutputSize < 1 {
+		return nil, errors.New("target scaling output size must be >= 1")
+	}
+	scaling := &TargetScaling{
+		outputSize: serialized.OutputSize,
+		scalers:    make([]TargetScaler, len(serialized.Scalers)),
+	}
+	for index, serializedScaler := range serialized.Scalers {
+		factory, err := targetScalers.lookup(serializedScaler.Name)
+		if err != nil {
+			return nil, err
+		}
+		scaler := factory.(func() TargetScaler)()
+		if err := json.Unmarshal(serializedScaler.Params, scaler); err != nil {
+			return nil, err
+		}
+		if !scaler.Fitted() {
+			return nil, errors.New(fmt.Sprintf("target scaler %v was saved unfitted", serializedScaler.Name))
+		}
+		if sized, ok := scaler.(SizedTargetScaler); ok {
+			if err := sized.CheckOutputSize(serialized.OutputSize); err != nil {
+				return nil, fmt.Errorf("target scaler %v: %w", index, err)
+			}
+		}
+		scaling.scalers[index] = scaler
+	}
+	return scaling, nil
+}
@@ -0,0 +1,105 @@
+package ffnn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"gonum.org/v1/gonum/mat"
+	"math"
+)
+
+// Element types of the tensors
+const (
+	Float64 = "float64"
+	Float32 = "float32"
+)
+
+func dtypeSize(dtype string) int {
+	switch dtype {
+	case Float64:
+		return 8
+	case Float32:
+		return 4
+	}
+	return 0
+}
+
+// A self-describing matrix: its element type, its shape (rows,
+//   columns) and its row-major elements as little-endian bytes.
+// In binary containers, Data is stored out of the document and
+//   Ref tells its index in the tensor table instead.
+type serializedTensor struct {
+	DType string
+	Shape []int
+	Data  []byte `json:",omitempty"`
+	Ref   *int   `json:",omitempty"`
+}
+
+func encodeTensor(m *mat.Dense) *serializedTensor {
+	rows, columns := m.Dims()
+	data := make([]byte, rows * columns * 8)
+	for i := 0; i < rows; i++ {
+		for j := 0; j < columns; j++ {
+			binary.LittleEndian.PutUint64(data[(i * columns + j) * 8:], math.Float64bits(m.At(i, j)))
+		}
+	}
+	return &serializedTensor{DType: Float64, Shape: []int{rows, columns}, Data: data}
+}
+
+// Changes the element type of the tensor, losing precision when
+//   going from float64 to float32
+func (tensor *serializedTensor) convert(dtype string) error {
+	if tensor.DType == dtype {
+		return nil
+	}
+	size := dtypeSize(dtype)
+	if size == 0 || dtypeSize(tensor.DType) == 0 {
+		return errors.New(fmt.Sprintf("cannot convert tensor from %q to %q", tensor.DType, dtype))
+	}
+	elements := tensor.elements()
+	data := make([]byte, len(elements) * size)
+	for index, element := range elements {
+		if dtype == Float32 {
+			binary.LittleEndian.PutUint32(data[index * 4:], math.Float32bits(float32(element)))
+		} else {
+			binary.LittleEndian.PutUint64(data[index * 8:], math.Float64bits(element))
+		}
+	}
+	tensor.DType, tensor.Data = dtype, data
+	return nil
+}
+
+// The elements as float64, assuming a valid dtype and data size
+func (tensor *serializedTensor) elements() []float64 {
+	size := dtypeSize(tensor.DType)
+	elements := make([]float64, len(tensor.Data) / size)
+	for index := range elements {
+		if tensor.DType == Float32 {
+			elements[index] = float64(math.Float32frombits(binary.LittleEndian.Uint32(tensor.Data[index * 4:])))
+		} else {
+			elements[index] = math.Float64frombits(binary.LittleEndian.Uint64(tensor.Data[index * 8:]))
+		}
+	}
+	return elements
+}
+
+// Decodes a tensor, checking it against the expected shape. The
+//   element tells which one is being decoded, for the errors.
+func decodeTensor(tensor *serializedTensor, expectedRows, expectedColumns int, element string) (*mat.Dense, error) {
+	if tensor == nil {
+		return nil, errors.New(fmt.Sprintf("%v tensor is missing", element))
+	}
+	size := dtypeSize(tensor.DType)
+	if size == 0 {
+		return nil, errors.New(fmt.Sprintf("%v tensor has unsupported dtype %q", element, tensor.DType))
+	}
+	if len(tensor.Shape) != 2 || tensor.Shape[0] != expectedRows || tensor.Shape[1] != expectedColumns {
+		return nil, errors.New(fmt.Sprintf(
+			"%v tensor shape %v does not match the expected [%v %v]", element, tensor.Shape, expectedRows, expectedColumns,
+		))
+	}
+	if len(tensor.Data) != expectedRows * expectedColumns * size {
+		return nil, errors.New(fmt.Sprintf("%v tensor data size does not match its shape", element))
+	}
+	return mat.NewDense(expectedRows, expectedColumns, tensor.elements()), nil
+}
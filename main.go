@@ -1,83 +1,683 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"time"
 	"os"
+	"io"
 	"math/rand"
-	"bufio"
+	"net"
+	"net/http"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
 	"./cmd"
+	"./dashboard"
+	"./datasets"
+	"./ffnn"
+	"./metrics"
+	"./monitoring"
+	"./serving"
+	"./tensorboard"
+	"./utils/matrices"
+	"./utils/random"
 )
 
 
-func trainNew() {
-	network := cmd.NewMNISTNetwork()
-	fmt.Println("Network created. Training...")
-	cmd.TrainMNISTNetwork(network, 5)
-	fmt.Println("Network trained. Saving...")
-	if err := cmd.SaveMNISTNetwork(network); err != nil {
-		fmt.Printf("Could not save the network! : %v\n", err)
-	} else {
-		fmt.Println("Network successfully saved.")
+// Exit codes
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+	// As shells do for processes killed by SIGINT
+	exitInterrupted = 130
+)
+
+
+type command struct {
+	name        string
+	description string
+	run         func(args []string) int
+}
+
+
+var commands []*command
+
+
+func init() {
+	commands = []*command{
+		{"train", "train a new network and save it", train},
+		{"resume", "train an existing network and save it", resume},
+		{"evaluate", "evaluate an existing network against a test file", evaluate},
+		{"predict", "predict csv, JSON Lines or image inputs in batches with an existing network", predict},
+		{"inspect", "print the structure and parameter statistics of an existing network", inspect},
+		{"serve", "serve the predictions of an existing network over HTTP", serve},
+	}
+}
+
+
+func usage(output io.Writer) {
+	fmt.Fprintf(output, "Usage: %v <command> [flags]\n\nCommands:\n", os.Args[0])
+	for _, command := range commands {
+		fmt.Fprintf(output, "  %-10s %v\n", command.name, command.description)
+	}
+	fmt.Fprintf(output, "\nUse \"%v <command> -h\" for the flags of a command.\n", os.Args[0])
+}
+
+
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(os.Stderr)
+	return flags
+}
+
+
+// Parses the flags, telling whether the command must stop, and
+//   with which exit code
+func parse(flags *flag.FlagSet, args []string) (int, bool) {
+	if err := flags.Parse(args); err == flag.ErrHelp {
+		return exitOK, true
+	} else if err != nil {
+		return exitUsage, true
+	}
+	if flags.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "Unexpected arguments: %v\n", flags.Args())
+		return exitUsage, true
 	}
+	return exitOK, false
+}
+
+
+func fail(format string, args ...interface{}) int {
+	fmt.Fprintf(os.Stderr, format + "\n", args...)
+	return exitFailure
 }
 
 
-func trainExisting() {
-	if network, err := cmd.LoadMNISTNetwork(); err != nil {
-		fmt.Printf("Could not load the network! : %v\n", err)
+type trainingFlags struct {
+	model   *string
+	data    *string
+	epochs  *int
+	rate    *float64
+	seed    *int64
+	augment *bool
+	format  *string
+	float32 *bool
+	gzip    *bool
+	signKey *string
+	// Schedule, validation and checkpoints
+	schedule        *string
+	validation      *string
+	best            *string
+	checkpoints     *string
+	keep            *int
+	checkpointEvery *int
+	// Training history
+	history     *string
+	logEvery    *int
+	tensorboard *string
+	dashboard   *string
+	metrics     *string
+	// Whether the training continues a former one
+	resuming bool
+}
+
+
+func addTrainingFlags(flags *flag.FlagSet) *trainingFlags {
+	return &trainingFlags{
+		model:   flags.String("model", cmd.Filename, "path of the network file"),
+		data:    flags.String("train", cmd.TrainingFile, "path of the MNIST csv training file"),
+		epochs:  flags.Int("epochs", 5, "number of training epochs"),
+		rate:    flags.Float64("rate", 0, "learning rate (0 uses the network's default)"),
+		seed:    flags.Int64("seed", 0, "random seed (0 uses the current time)"),
+		augment: flags.Bool("augment", false, "augment the training images on-the-fly"),
+		format:  flags.String("format", "json", "format of the saved network: json or binary"),
+		float32: flags.Bool("float32", false, "save the tensors with single precision"),
+		gzip:    flags.Bool("gzip", false, "compress the saved network with gzip"),
+		signKey: flags.String("sign-key", "", "path of a file with the key to sign the saved network (HMAC-SHA256)"),
+		schedule: flags.String(
+			"schedule", "", "learning rate schedule, as Name[:key=value,...] (e.g. StepDecay:every=2,factor=0.5)",
+		),
+		validation:      flags.String("validate", "", "path of an MNIST csv file to validate against after each epoch"),
+		best:            flags.String("best", "", "path to save the network with the best validation accuracy"),
+		checkpoints:     flags.String("checkpoints", "", "directory to save training checkpoints into (none by default)"),
+		keep:            flags.Int("keep", 3, "how many checkpoints to keep (0 keeps all of them)"),
+		checkpointEvery: flags.Int("checkpoint-every", 0, "also save a checkpoint every this many samples (0: only after each epoch)"),
+		history:         flags.String("history", "", "path of the training history to write: .csv, or .jsonl for JSON Lines"),
+		logEvery:        flags.Int("log-every", 100, "write a history record every this many steps (0: only per epoch)"),
+		tensorboard:     flags.String("tensorboard", "", "directory to write TensorBoard events into (none by default)"),
+		dashboard:       flags.String("dashboard", "", "address to serve the live training dashboard on (e.g. localhost:8080)"),
+		metrics:         flags.String("metrics", "", "address to serve the training metrics on, for Prometheus (e.g. localhost:9090)"),
+	}
+}
+
+
+// Seeds the generators, before anything random is created
+func (t *trainingFlags) seedRandom() {
+	if *t.seed == 0 {
+		*t.seed = time.Now().UTC().UnixNano()
+	}
+	matrices.Seed(*t.seed)
+	fmt.Printf("Using random seed: %v\n", *t.seed)
+}
+
+
+func (t *trainingFlags) options() cmd.TrainingOptions {
+	source := random.NewSource(*t.seed)
+	options := cmd.TrainingOptions{
+		Filename: *t.data, Epochs: *t.epochs, LearningRate: *t.rate, Source: source,
+		ValidationFilename: *t.validation, BestFilename: *t.best, CheckpointEvery: *t.checkpointEvery,
+		LogEvery: *t.logEvery, Hyperparameters: map[string]string{"seed": strconv.FormatInt(*t.seed, 10)},
+	}
+	options.Schedule, _ = cmd.ParseSchedule(*t.schedule)
+	if *t.checkpoints != "" {
+		options.Checkpoints = &ffnn.CheckpointRotation{Directory: *t.checkpoints, Keep: *t.keep}
 	} else {
-		fmt.Println("Network loaded. Training...")
-		cmd.TrainMNISTNetwork(network, 5)
-		fmt.Println("Network trained. Saving...")
-		if err := cmd.SaveMNISTNetwork(network); err != nil {
-			fmt.Printf("Could not save the network! : %v\n", err)
-		} else {
-			fmt.Println("Network successfully saved.")
+		options.InterruptFilename = strings.TrimSuffix(*t.model, ".ffnn") + ".ckpt"
+	}
+	if *t.augment {
+		options.Augmentation = datasets.DefaultDigitAugmentation()
+		options.Random = rand.New(source)
+	}
+	return options
+}
+
+
+func (t *trainingFlags) validate() bool {
+	if *t.epochs < 1 {
+		fmt.Fprintln(os.Stderr, "Epochs must be >= 1")
+		return false
+	}
+	if *t.rate < 0 {
+		fmt.Fprintln(os.Stderr, "Learning rate must be positive")
+		return false
+	}
+	if *t.keep < 0 || *t.checkpointEvery < 0 {
+		fmt.Fprintln(os.Stderr, "The checkpoints to keep, and the samples between checkpoints, must be >= 0")
+		return false
+	}
+	if *t.best != "" && *t.validation == "" {
+		fmt.Fprintln(os.Stderr, "Saving the best network requires a validation file")
+		return false
+	}
+	if *t.logEvery < 0 {
+		fmt.Fprintln(os.Stderr, "The steps between history records must be >= 0")
+		return false
+	}
+	if *t.history != "" {
+		if _, err := metrics.NewHistoryFor(*t.history, io.Discard, false); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return false
+		}
+	}
+	if _, err := cmd.ParseSchedule(*t.schedule); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return false
+	}
+	if _, err := t.saveOptions(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return false
+	}
+	return true
+}
+
+
+func (t *trainingFlags) saveOptions() (ffnn.SaveOptions, error) {
+	options, err := cmd.ParseSaveOptions(*t.format, *t.float32, *t.gzip)
+	if err != nil {
+		return options, err
+	}
+	options.SigningKey, err = cmd.ReadKey(*t.signKey)
+	return options, err
+}
+
+
+type loadingFlags struct {
+	verifyKey       *string
+	requireChecksum *bool
+}
+
+
+func addLoadingFlags(flags *flag.FlagSet) *loadingFlags {
+	return &loadingFlags{
+		verifyKey:       flags.String("verify-key", "", "path of a file with the key the network must be signed with"),
+		requireChecksum: flags.Bool("require-checksum", false, "refuse networks saved without checksum"),
+	}
+}
+
+
+func (l *loadingFlags) load(model string) (*ffnn.FFNetwork, error) {
+	key, err := cmd.ReadKey(*l.verifyKey)
+	if err != nil {
+		return nil, err
+	}
+	return cmd.LoadNetworkWith(model, ffnn.LoadOptions{RequireChecksum: *l.requireChecksum, VerificationKey: key})
+}
+
+
+// Serves the metrics at /metrics in the background. Addresses
+//   without host (e.g. ":9090") listen on localhost only.
+func serveMetrics(registry *monitoring.Registry, address string) (*http.Server, string, error) {
+	if host, port, err := net.SplitHostPort(address); err != nil {
+		return nil, "", err
+	} else if host == "" {
+		address = net.JoinHostPort("localhost", port)
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, "", err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", registry.Handler())
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go server.Serve(listener)
+	return server, fmt.Sprintf("http://%v/metrics", listener.Addr()), nil
+}
+
+
+// Trains the network, or resumes the checkpoint when given, and
+//   saves the network. SIGINT and SIGTERM stop the training,
+//   leaving a checkpoint behind.
+func trainAndSave(network *ffnn.FFNetwork, checkpoint *ffnn.Checkpoint, t *trainingFlags) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		// A second signal kills the process as usual
+		<-ctx.Done()
+		stop()
+	}()
+
+	options := t.options()
+	if *t.history != "" {
+		// Resumed trainings continue the history
+		flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if t.resuming {
+			flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		file, err := os.OpenFile(*t.history, flag, 0644)
+		if err != nil {
+			return fail("Could not create the history file! : %v", err)
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil {
+			return fail("Could not create the history file! : %v", err)
+		}
+		options.History, _ = metrics.NewHistoryFor(*t.history, file, info.Size() == 0)
+		defer options.History.Close()
+	}
+	if *t.tensorboard != "" {
+		writer, err := tensorboard.NewWriter(*t.tensorboard)
+		if err != nil {
+			return fail("Could not create the TensorBoard events! : %v", err)
+		}
+		defer writer.Close()
+		options.TensorBoard = writer
+	}
+	if *t.dashboard != "" {
+		board := dashboard.New()
+		url, err := board.Start(*t.dashboard)
+		if err != nil {
+			return fail("Could not start the dashboard! : %v", err)
 		}
+		defer board.Close()
+		options.Dashboard = board
+		fmt.Println("Dashboard:", url)
 	}
+	if *t.metrics != "" {
+		registry := monitoring.NewRegistry()
+		server, url, err := serveMetrics(registry, *t.metrics)
+		if err != nil {
+			return fail("Could not serve the metrics! : %v", err)
+		}
+		defer server.Close()
+		options.Metrics = registry
+		fmt.Println("Metrics:", url)
+	}
+
+	fmt.Println("Training...")
+	var err error
+	if checkpoint != nil {
+		network = checkpoint.Network
+		err = cmd.ResumeMNISTContext(ctx, checkpoint, options)
+	} else {
+		err = cmd.TrainMNISTContext(ctx, network, options)
+	}
+	if errors.Is(err, cmd.ErrInterrupted) {
+		fmt.Fprintf(os.Stderr, "%v. Use \"resume -checkpoint\" to continue.\n", err)
+		return exitInterrupted
+	} else if err != nil {
+		return fail("Could not train the network! : %v", err)
+	}
+	fmt.Println("Network trained. Saving...")
+	saveOptions, _ := t.saveOptions()
+	if err := cmd.SaveNetworkWith(network, *t.model, saveOptions); err != nil {
+		return fail("Could not save the network! : %v", err)
+	}
+	fmt.Println("Network successfully saved.")
+	return exitOK
 }
 
 
-func testExisting() {
-	if network, err := cmd.LoadMNISTNetwork(); err != nil {
-		fmt.Printf("Could not load the network! : %v\n", err)
+func train(args []string) int {
+	flags := newFlagSet("train")
+	t := addTrainingFlags(flags)
+	architecture := flags.String("arch", cmd.DefaultArchitecture, "comma-separated layers as size[:Activator], ending in the 10-sized output")
+	config := flags.String("config", "", "path of a JSON network config (replaces -arch)")
+	if code, stop := parse(flags, args); stop {
+		return code
+	}
+	if !t.validate() {
+		return exitUsage
+	}
+	t.seedRandom()
+
+	var network *ffnn.FFNetwork
+	var err error
+	if *config != "" {
+		archSet := false
+		flags.Visit(func(f *flag.Flag) {
+			archSet = archSet || f.Name == "arch"
+		})
+		if archSet {
+			fmt.Fprintln(os.Stderr, "Only one of -arch and -config can be given")
+			return exitUsage
+		}
+		network, err = cmd.NewMNISTNetworkFromConfig(*config)
 	} else {
-		fmt.Println("Network loaded. Testing...")
-		cmd.TestMNISTNetwork(network)
-		fmt.Println("Network tested.")
-	}
-}
-
-
-var keyboardInput = bufio.NewReader(os.Stdin)
-func menu() {
-	for {
-		fmt.Print("Choose your option (train (n)ew, train (e)xisting, (t)est or (q)uit):")
-		if result, err := keyboardInput.ReadString('\n'); err == nil {
-			result = strings.TrimRight(result,"\n")
-			switch result {
-			case "n":
-				trainNew()
-			case "e":
-				trainExisting()
-			case "t":
-				testExisting()
-			case "q":
-				fmt.Println("Have a nice day!")
+		rate := *t.rate
+		if rate == 0 {
+			rate = cmd.DefaultLearningRate
+		}
+		network, err = cmd.NewMNISTNetworkWith(*architecture, rate)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not create the network! : %v\n", err)
+		return exitUsage
+	}
+	fmt.Println("Network created.")
+	return trainAndSave(network, nil, t)
+}
+
+
+func resume(args []string) int {
+	flags := newFlagSet("resume")
+	t := addTrainingFlags(flags)
+	l := addLoadingFlags(flags)
+	from := flags.String(
+		"checkpoint", "",
+		"checkpoint file, or directory (its latest one), to resume instead of -model; -epochs is then the total",
+	)
+	if code, stop := parse(flags, args); stop {
+		return code
+	}
+	if !t.validate() {
+		return exitUsage
+	}
+	t.seedRandom()
+	t.resuming = true
+
+	if *from != "" {
+		filename, checkpoint, err := cmd.LoadCheckpoint(*from)
+		if err != nil {
+			return fail("Could not load the checkpoint! : %v", err)
+		}
+		fmt.Println("Checkpoint loaded:", filename)
+		// Resuming a rotation keeps rotating it, while single files
+		//   (as the interrupted trainings leave) stay single files
+		if info, err := os.Stat(*from); err == nil && info.IsDir() && *t.checkpoints == "" {
+			*t.checkpoints = *from
+		}
+		return trainAndSave(nil, checkpoint, t)
+	}
+
+	network, err := l.load(*t.model)
+	if err != nil {
+		return fail("Could not load the network! : %v", err)
+	}
+	fmt.Println("Network loaded.")
+	return trainAndSave(network, nil, t)
+}
+
+
+func evaluate(args []string) int {
+	flags := newFlagSet("evaluate")
+	model := flags.String("model", cmd.Filename, "path of the network file")
+	data := flags.String("test", cmd.TestingFile, "path of the MNIST csv testing file")
+	verbose := flags.Bool("verbose", false, "print each case")
+	asJSON := flags.Bool("json", false, "write the reports as JSON")
+	l := addLoadingFlags(flags)
+	if code, stop := parse(flags, args); stop {
+		return code
+	}
+
+	network, err := l.load(*model)
+	if err != nil {
+		return fail("Could not load the network! : %v", err)
+	}
+	if err := cmd.EvaluateMNIST(network, cmd.EvaluationOptions{
+		Filename: *data, Verbose: *verbose, JSON: *asJSON,
+	}); err != nil {
+		return fail("Could not evaluate the network! : %v", err)
+	}
+	return exitOK
+}
+
+
+func predict(args []string) int {
+	flags := newFlagSet("predict")
+	model := flags.String("model", cmd.Filename, "path of the network file")
+	input := flags.String("input", "", "path of the csv or JSON Lines file, or the image folder, to predict")
+	inputFormat := flags.String("input-format", "", "csv, jsonl or images (default: by the input path)")
+	output := flags.String("output", "", "path of the csv or JSON Lines file to write (default: standard output)")
+	outputFormat := flags.String("output-format", "", "csv or jsonl (default: by the output path)")
+	mode := flags.String("mode", cmd.ArgmaxPredictions, "what to write: argmax, raw (all the outputs) or topk")
+	k := flags.Int("k", 3, "how many labels to write in the topk mode")
+	batch := flags.Int("batch", 256, "inputs predicted at once")
+	labels := flags.String("labels", "", "path of the labels file naming the outputs (default: their indices)")
+	width := flags.Int("width", 0, "width the images are resized to (default: square by the network inputs)")
+	height := flags.Int("height", 0, "height the images are resized to")
+	rgb := flags.Bool("rgb", false, "read the images in RGB rather than grayscale")
+	low := flags.Float64("low", 0, "value of the black image pixels")
+	high := flags.Float64("high", 255, "value of the white image pixels")
+	l := addLoadingFlags(flags)
+	if code, stop := parse(flags, args); stop {
+		return code
+	}
+	if *input == "" {
+		fmt.Fprintln(os.Stderr, "An input file is required")
+		return exitUsage
+	}
+	if *outputFormat != "" && *outputFormat != "csv" && *outputFormat != "jsonl" {
+		fmt.Fprintf(os.Stderr, "Unknown output format: %v\n", *outputFormat)
+		return exitUsage
+	}
+
+	network, err := l.load(*model)
+	if err != nil {
+		return fail("Could not load the network! : %v", err)
+	}
+	options := cmd.PredictionOptions{Mode: *mode, K: *k, BatchSize: *batch}
+	if *labels != "" {
+		if options.Labels, err = datasets.LoadLabels(*labels); err != nil {
+			return fail("Could not load the labels! : %v", err)
+		}
+	}
+	imageOptions := datasets.ImageFolderOptions{Width: *width, Height: *height, Low: *low, High: *high}
+	if *rgb {
+		imageOptions.Mode = datasets.RGB
+	}
+	inputs, err := cmd.OpenInputs(*input, *inputFormat, network.InputSize(), imageOptions)
+	if err != nil {
+		return fail("Could not open the inputs! : %v", err)
+	}
+	defer inputs.Close()
+
+	var writer io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fail("Could not create the output file! : %v", err)
+		}
+		defer file.Close()
+		writer = file
+	}
+	// Buffered, as predictions are written one by one
+	buffered := bufio.NewWriter(writer)
+	format := *output
+	if *outputFormat != "" {
+		format = "." + *outputFormat
+	}
+	newWriter := func(options cmd.PredictionOptions) cmd.PredictionWriter {
+		return cmd.NewPredictionsFor(format, buffered, inputs.IDName(), options)
+	}
+	if _, err := cmd.PredictAll(network, inputs, newWriter, options); err != nil {
+		return fail("Could not predict! : %v", err)
+	}
+	if err := buffered.Flush(); err != nil {
+		return fail("Could not write the predictions! : %v", err)
+	}
+	return exitOK
+}
+
+
+func inspect(args []string) int {
+	flags := newFlagSet("inspect")
+	model := flags.String("model", cmd.Filename, "path of the network file")
+	asJSON := flags.Bool("json", false, "write the summary as JSON")
+	l := addLoadingFlags(flags)
+	if code, stop := parse(flags, args); stop {
+		return code
+	}
+
+	network, err := l.load(*model)
+	if err != nil {
+		return fail("Could not load the network! : %v", err)
+	}
+	if err := cmd.Inspect(network, os.Stdout, *asJSON); err != nil {
+		return fail("Could not inspect the network! : %v", err)
+	}
+	return exitOK
+}
+
+
+func serve(args []string) int {
+	flags := newFlagSet("serve")
+	model := flags.String("model", cmd.Filename, "path of the network file")
+	address := flags.String("address", "localhost:8080", "address to listen on")
+	maxBody := flags.Int64("max-body", serving.DefaultMaxBodyBytes, "largest request body accepted, in bytes")
+	maxBatch := flags.Int("max-batch", serving.DefaultMaxBatchSize, "most inputs accepted in a batch")
+	watch := flags.Duration("watch", 0, "reload the network when its file changes, checking this often (e.g. 2s; 0: never)")
+	drain := flags.Duration(
+		"drain", 0, "on shutdown, keep serving this long with /readyz failing, so load balancers stop sending requests first",
+	)
+	l := addLoadingFlags(flags)
+	if code, stop := parse(flags, args); stop {
+		return code
+	}
+	if *maxBody < 1 || *maxBatch < 1 {
+		fmt.Fprintln(os.Stderr, "The request limits must be >= 1")
+		return exitUsage
+	}
+	if *watch < 0 || *drain < 0 {
+		fmt.Fprintln(os.Stderr, "The watch interval and drain period must be >= 0")
+		return exitUsage
+	}
+
+	network, err := l.load(*model)
+	if err != nil {
+		return fail("Could not load the network! : %v", err)
+	}
+	handler := serving.NewHandler(network, serving.Options{MaxBodyBytes: *maxBody, MaxBatchSize: *maxBatch})
+	server := &http.Server{Addr: *address, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+
+	// SIGINT and SIGTERM stop the server, letting the requests in
+	//   progress finish
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// SIGHUP, or a change of the file when watching, reloads the
+	//   network in the background
+	load := func() (*ffnn.FFNetwork, error) {
+		return l.load(*model)
+	}
+	report := func(swapped bool, err error) {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not reload the network, still serving version %v! : %v\n", handler.Version().Version, err)
+		} else if swapped {
+			fmt.Printf("Network reloaded: version %v\n", handler.Version().Version)
+		} else {
+			fmt.Println("Network unchanged, not reloaded.")
+		}
+	}
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
 				return
+			case <-hangups:
+				report(handler.Reload(load))
 			}
-		} else {
-			fmt.Printf("Breaking in menu due to error : %v", err)
-			return
 		}
+	}()
+	if *watch > 0 {
+		// As the network is loaded
+		watched := strings.TrimSuffix(*model, ".ffnn") + ".ffnn"
+		go handler.WatchFile(ctx, watched, *watch, load, report)
 	}
+	served := make(chan error, 1)
+	go func() {
+		served <- server.ListenAndServe()
+	}()
+	fmt.Printf("Serving %v on %v\n", *model, *address)
+	select {
+	case err := <-served:
+		return fail("Could not serve! : %v", err)
+	case <-ctx.Done():
+	}
+	stop()
+	fmt.Println("Shutting down...")
+	// Meanwhile a second signal kills the process, as stop was called
+	handler.Drain()
+	time.Sleep(*drain)
+	shutdown, cancel := context.WithTimeout(context.Background(), 30 * time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdown); err != nil {
+		return fail("Could not shut down cleanly! : %v", err)
+	}
+	return exitOK
+}
+
+
+func run(args []string) int {
+	if len(args) == 0 {
+		usage(os.Stderr)
+		return exitUsage
+	}
+	switch args[0] {
+	case "-h", "-help", "--help", "help":
+		usage(os.Stdout)
+		return exitOK
+	}
+	for _, command := range commands {
+		if command.name == args[0] {
+			return command.run(args[1:])
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Unknown command: %v\n\n", args[0])
+	usage(os.Stderr)
+	return exitUsage
 }
 
 
 func main() {
-	// Prepare the random
-	rand.Seed(time.Now().UTC().UnixNano())
-	menu()
-}
\ No newline at end of file
+	os.Exit(run(os.Args[1:]))
+}
@@ -0,0 +1,312 @@
+package metrics
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Index of the highest output
+func Argmax(outputs mat.Matrix) int {
+	return TopK(outputs, 1)[0]
+}
+
+// Indices of the k highest outputs, in descending order
+func TopK(outputs mat.Matrix, k int) []int {
+	rows, _ := outputs.Dims()
+	indices := make([]int, rows)
+	for index := range indices {
+		indices[index] = index
+	}
+	sort.SliceStable(indices, func(i, j int) bool {
+		return outputs.At(indices[i], 0) > outputs.At(indices[j], 0)
+	})
+	if k > rows {
+		k = rows
+	}
+	return indices[:k]
+}
+
+// The outputs as probabilities: normalized to sum 1 when they are
+//   all non-negative (as sigmoid outputs), or else their softmax
+func Probabilities(outputs mat.Matrix) []float64 {
+	rows, _ := outputs.Dims()
+	probabilities := make([]float64, rows)
+	sum, max := 0.0, math.Inf(-1)
+	negative := false
+	for index := range probabilities {
+		value := outputs.At(index, 0)
+		probabilities[index] = value
+		sum += value
+		max = math.Max(max, value)
+		negative = negative || value < 0
+	}
+	if negative || sum == 0 {
+		// Shifted by the max, so the exponentials cannot overflow
+		sum = 0
+		for index, value := range probabilities {
+			probabilities[index] = math.Exp(value - max)
+			sum += probabilities[index]
+		}
+	}
+	for index := range probabilities {
+		probabilities[index] /= sum
+	}
+	return probabilities
+}
+
+// Accumulates predictions against expected classes over a full
+//   evaluation. The confusion matrix is indexed as
+//   [expected][predicted].
+type Classification struct {
+	labels    []string
+	topK      int
+	confusion [][]int
+	count     int
+	topKHits  int
+}
+
+// Creates an accumulator for the given number of classes, which
+//   also tracks the top-k accuracy for the given k (>= 1).
+func NewClassification(classes int, topK int) *Classification {
+	if classes < 1 {
+		panic("classes must be >= 1")
+	}
+	if topK < 1 {
+		panic("top-k must be >= 1")
+	}
+
+	labels := make([]string, classes)
+	confusion := make([][]int, classes)
+	for index := range confusion {
+		labels[index] = fmt.Sprint(index)
+		confusion[index] = make([]int, classes)
+	}
+	return &Classification{labels: labels, topK: topK, confusion: confusion}
+}
+
+// Names the classes in the reports (by default, their indices)
+func (c *Classification) WithLabels(labels []string) *Classification {
+	if len(labels) != len(c.labels) {
+		panic("labels count must match the classes count")
+	}
+	c.labels = append([]string{}, labels...)
+	return c
+}
+
+func (c *Classification) Classes() int {
+	return len(c.labels)
+}
+
+func (c *Classification) Count() int {
+	return c.count
+}
+
+// Adds a case given the (classes, 1) outputs of a network. Cases
+//   of unknown classes (or outputs of another size) are rejected.
+func (c *Classification) Add(outputs mat.Matrix, expected int) error {
+	if rows, _ := outputs.Dims(); rows != len(c.labels) {
+		return errors.New(fmt.Sprintf("expected outputs for %v classes, got %v", len(c.labels), rows))
+	}
+	if expected < 0 || expected >= len(c.labels) {
+		return errors.New(fmt.Sprintf("expected class %v out of range [0, %v)", expected, len(c.labels)))
+	}
+	top := TopK(outputs, c.topK)
+	for _, index := range top {
+		if index == expected {
+			c.topKHits++
+			break
+		}
+	}
+	c.confusion[expected][top[0]]++
+	c.count++
+	return nil
+}
+
+func (c *Classification) ConfusionMatrix() [][]int {
+	return c.confusion
+}
+
+func (c *Classification) Accuracy() float64 {
+	hits := 0
+	for class := range c.confusion {
+		hits += c.confusion[class][class]
+	}
+	return ratio(hits, c.count)
+}
+
+func (c *Classification) TopKAccuracy() float64 {
+	return ratio(c.topKHits, c.count)
+}
+
+func (c *Classification) truePositives(class int) int {
+	return c.confusion[class][class]
+}
+
+// Cases predicted as the class
+func (c *Classification) predicted(class int) int {
+	total := 0
+	for expected := range c.confusion {
+		total += c.confusion[expected][class]
+	}
+	return total
+}
+
+// Cases actually belonging to the class
+func (c *Classification) support(class int) int {
+	total := 0
+	for _, count := range c.confusion[class] {
+		total += count
+	}
+	return total
+}
+
+func (c *Classification) Precision(class int) float64 {
+	return ratio(c.truePositives(class), c.predicted(class))
+}
+
+func (c *Classification) Recall(class int) float64 {
+	return ratio(c.truePositives(class), c.support(class))
+}
+
+func (c *Classification) F1(class int) float64 {
+	return f1(c.Precision(class), c.Recall(class))
+}
+
+// Unweighted means of the per-class precision, recall and F1
+func (c *Classification) Macro() (float64, float64, float64) {
+	precision, recall, f := 0.0, 0.0, 0.0
+	classes := float64(len(c.confusion))
+	for class := range c.confusion {
+		precision += c.Precision(class) / classes
+		recall += c.Recall(class) / classes
+		f += c.F1(class) / classes
+	}
+	return precision, recall, f
+}
+
+// Precision, recall and F1 from the counts summed among classes.
+// For single-label classification all of them match the accuracy.
+func (c *Classification) Micro() (float64, float64, float64) {
+	truePositives, predicted, support := 0, 0, 0
+	for class := range c.confusion {
+		truePositives += c.truePositives(class)
+		predicted += c.predicted(class)
+		support += c.support(class)
+	}
+	precision, recall := ratio(truePositives, predicted), ratio(truePositives, support)
+	return precision, recall, f1(precision, recall)
+}
+
+type ClassMetrics struct {
+	Label     string  `json:"label"`
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+	F1        float64 `json:"f1"`
+	Support   int     `json:"support"`
+}
+
+type Averages struct {
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+	F1        float64 `json:"f1"`
+}
+
+// A snapshot of the accumulated metrics, ready to be written
+type ClassificationReport struct {
+	Count           int            `json:"count"`
+	Accuracy        float64        `json:"accuracy"`
+	TopK            int            `json:"top_k"`
+	TopKAccuracy    float64        `json:"top_k_accuracy"`
+	Classes         []ClassMetrics `json:"classes"`
+	Macro           Averages       `json:"macro"`
+	Micro           Averages       `json:"micro"`
+	ConfusionMatrix [][]int        `json:"confusion_matrix"`
+}
+
+func (c *Classification) Report() *ClassificationReport {
+	report := &ClassificationReport{
+		Count:           c.count,
+		Accuracy:        c.Accuracy(),
+		TopK:            c.topK,
+		TopKAccuracy:    c.TopKAccuracy(),
+		Classes:         make([]ClassMetrics, len(c.labels)),
+		ConfusionMatrix: make([][]int, len(c.confusion)),
+	}
+	for class, label := range c.labels {
+		report.Classes[class] = ClassMetrics{
+			Label:     label,
+			Precision: c.Precision(class),
+			Recall:    c.Recall(class),
+			F1:        c.F1(class),
+			Support:   c.support(class),
+		}
+		report.ConfusionMatrix[class] = append([]int{}, c.confusion[class]...)
+	}
+	report.Macro.Precision, report.Macro.Recall, report.Macro.F1 = c.Macro()
+	report.Micro.Precision, report.Micro.Recall, report.Micro.F1 = c.Micro()
+	return report
+}
+
+func (report *ClassificationReport) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+func (report *ClassificationReport) WriteText(w io.Writer) error {
+	builder := &strings.Builder{}
+	fmt.Fprintf(builder, "Cases: %v\n", report.Count)
+	fmt.Fprintf(builder, "Accuracy: %.4f\n", report.Accuracy)
+	fmt.Fprintf(builder, "Top-%v accuracy: %.4f\n\n", report.TopK, report.TopKAccuracy)
+
+	width := len("class")
+	for _, class := range report.Classes {
+		if len(class.Label) > width {
+			width = len(class.Label)
+		}
+	}
+	fmt.Fprintf(builder, "%*s %10s %10s %10s %10s\n", width, "class", "precision", "recall", "f1", "support")
+	for _, class := range report.Classes {
+		fmt.Fprintf(builder, "%*s %10.4f %10.4f %10.4f %10d\n", width, class.Label, class.Precision, class.Recall, class.F1, class.Support)
+	}
+	fmt.Fprintf(builder, "%*s %10.4f %10.4f %10.4f %10d\n", width, "macro", report.Macro.Precision, report.Macro.Recall, report.Macro.F1, report.Count)
+	fmt.Fprintf(builder, "%*s %10.4f %10.4f %10.4f %10d\n\n", width, "micro", report.Micro.Precision, report.Micro.Recall, report.Micro.F1, report.Count)
+
+	builder.WriteString("Confusion matrix (rows: expected, columns: predicted):\n")
+	fmt.Fprintf(builder, "%*s", width, "")
+	for _, class := range report.Classes {
+		fmt.Fprintf(builder, " %6s", class.Label)
+	}
+	builder.WriteString("\n")
+	for expected, row := range report.ConfusionMatrix {
+		fmt.Fprintf(builder, "%*s", width, report.Classes[expected].Label)
+		for _, count := range row {
+			fmt.Fprintf(builder, " %6d", count)
+		}
+		builder.WriteString("\n")
+	}
+
+	_, err := io.WriteString(w, builder.String())
+	return err
+}
+
+func ratio(numerator, denominator int) float64 {
+	if denominator == 0 {
+		return 0
+	}
+	return float64(numerator) / float64(denominator)
+}
+
+func f1(precision, recall float64) float64 {
+	if precision+recall == 0 {
+		return 0
+	}
+	return 2 * precision * recall / (precision + recall)
+}
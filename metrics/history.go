@@ -0,0 +1,163 @@
+package metrics
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A record of the training history. Loss, accuracy and gradient
+//   norm are averaged over the steps since the previous record of
+//   the same kind.
+type HistoryRecord struct {
+	// "step" or "epoch"
+	Kind  string `json:"kind"`
+	// The (0-based) epoch, and the total training updates so far
+	Epoch int    `json:"epoch"`
+	Step  int    `json:"step"`
+	Loss     float64 `json:"loss"`
+	Accuracy float64 `json:"accuracy"`
+	// Only in epoch records, when validating
+	ValidationAccuracy *float64 `json:"validation_accuracy,omitempty"`
+	LearningRate       float64  `json:"learning_rate"`
+	GradientNorm       float64  `json:"gradient_norm"`
+	// Seconds since the training session started
+	WallTime float64 `json:"wall_time"`
+}
+
+const (
+	StepRecord  = "step"
+	EpochRecord = "epoch"
+)
+
+// A value encoded as null when it is not finite (as when the
+//   training diverges), since JSON cannot represent it
+type Number float64
+
+func (number Number) MarshalJSON() ([]byte, error) {
+	if value := float64(number); math.IsNaN(value) || math.IsInf(value, 0) {
+		return []byte("null"), nil
+	}
+	return json.Marshal(float64(number))
+}
+
+// Encodes the non-finite values as null (see Number)
+func (record HistoryRecord) MarshalJSON() ([]byte, error) {
+	encoded := struct {
+		Kind               string  `json:"kind"`
+		Epoch              int     `json:"epoch"`
+		Step               int     `json:"step"`
+		Loss               Number  `json:"loss"`
+		Accuracy           Number  `json:"accuracy"`
+		ValidationAccuracy *Number `json:"validation_accuracy,omitempty"`
+		LearningRate       Number  `json:"learning_rate"`
+		GradientNorm       Number  `json:"gradient_norm"`
+		WallTime           float64 `json:"wall_time"`
+	}{
+		Kind:         record.Kind,
+		Epoch:        record.Epoch,
+		Step:         record.Step,
+		Loss:         Number(record.Loss),
+		Accuracy:     Number(record.Accuracy),
+		LearningRate: Number(record.LearningRate),
+		GradientNorm: Number(record.GradientNorm),
+		WallTime:     record.WallTime,
+	}
+	if record.ValidationAccuracy != nil {
+		accuracy := Number(*record.ValidationAccuracy)
+		encoded.ValidationAccuracy = &accuracy
+	}
+	return json.Marshal(encoded)
+}
+
+// Writes the training history somewhere, record by record. Close
+//   flushes the pending records (but does not close the writer).
+type HistoryWriter interface {
+	Write(record *HistoryRecord) error
+	Close() error
+}
+
+
+// One JSON object per line
+type jsonLinesHistory struct {
+	encoder *json.Encoder
+}
+
+func NewJSONLinesHistory(w io.Writer) HistoryWriter {
+	return &jsonLinesHistory{encoder: json.NewEncoder(w)}
+}
+
+func (history *jsonLinesHistory) Write(record *HistoryRecord) error {
+	return history.encoder.Encode(record)
+}
+
+func (history *jsonLinesHistory) Close() error {
+	return nil
+}
+
+
+var historyColumns = []string{
+	"kind", "epoch", "step", "loss", "accuracy", "validation_accuracy", "learning_rate", "gradient_norm", "wall_time",
+}
+
+// CSV with a header, leaving the missing values empty
+type csvHistory struct {
+	writer *csv.Writer
+	// Whether the header was written (or omitted) already
+	headerDone bool
+}
+
+// The header can be omitted, e.g. when appending to a history
+func NewCSVHistory(w io.Writer, header bool) HistoryWriter {
+	return &csvHistory{writer: csv.NewWriter(w), headerDone: !header}
+}
+
+func (history *csvHistory) Write(record *HistoryRecord) error {
+	if !history.headerDone {
+		history.headerDone = true
+		if err := history.writer.Write(historyColumns); err != nil {
+			return err
+		}
+	}
+	format := func(value float64) string {
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+	validation := ""
+	if record.ValidationAccuracy != nil {
+		validation = format(*record.ValidationAccuracy)
+	}
+	err := history.writer.Write([]string{
+		record.Kind, strconv.Itoa(record.Epoch), strconv.Itoa(record.Step), format(record.Loss), format(record.Accuracy),
+		validation, format(record.LearningRate), format(record.GradientNorm), format(record.WallTime),
+	})
+	if err != nil {
+		return err
+	}
+	// Flushing each record, so the history can be followed live
+	history.writer.Flush()
+	return history.writer.Error()
+}
+
+func (history *csvHistory) Close() error {
+	history.writer.Flush()
+	return history.writer.Error()
+}
+
+
+// Creates the history writer for a file name: CSV (with or without
+//   header) for ".csv", and JSON Lines for ".jsonl" or ".json"
+func NewHistoryFor(filename string, w io.Writer, header bool) (HistoryWriter, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		return NewCSVHistory(w, header), nil
+	case ".jsonl", ".json":
+		return NewJSONLinesHistory(w), nil
+	}
+	return nil, errors.New(fmt.Sprintf("unknown history format for %v (expected .csv, .jsonl or .json)", filename))
+}
@@ -0,0 +1,296 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Quantiles reported for the residuals
+var residualQuantiles = []float64{0, 0.05, 0.25, 0.5, 0.75, 0.95, 1}
+
+// Accumulates outputs against expected values over a full
+//   evaluation. Residuals are kept (as output - expected) for
+//   the quantiles and histograms. The means and variances are
+//   accumulated with Welford's method, which stays accurate for
+//   values far from zero.
+type Regression struct {
+	labels    []string
+	count     int
+	meanT     []float64
+	m2T       []float64
+	meanR     []float64
+	m2R       []float64
+	sumR2     []float64
+	sumAbsR   []float64
+	sumPct    []float64
+	countPct  []int
+	residuals [][]float64
+}
+
+func NewRegression(outputs int) *Regression {
+	if outputs < 1 {
+		panic("outputs must be >= 1")
+	}
+
+	labels := make([]string, outputs)
+	for index := range labels {
+		labels[index] = fmt.Sprint(index)
+	}
+	return &Regression{
+		labels:    labels,
+		meanT:     make([]float64, outputs),
+		m2T:       make([]float64, outputs),
+		meanR:     make([]float64, outputs),
+		m2R:       make([]float64, outputs),
+		sumR2:     make([]float64, outputs),
+		sumAbsR:   make([]float64, outputs),
+		sumPct:    make([]float64, outputs),
+		countPct:  make([]int, outputs),
+		residuals: make([][]float64, outputs),
+	}
+}
+
+// Names the outputs in the reports (by default, their indices)
+func (r *Regression) WithLabels(labels []string) *Regression {
+	if len(labels) != len(r.labels) {
+		panic("labels count must match the outputs count")
+	}
+	r.labels = append([]string{}, labels...)
+	return r
+}
+
+func (r *Regression) Count() int {
+	return r.count
+}
+
+// Adds a case given the (outputs, 1) outputs and expected values
+func (r *Regression) Add(outputs, expected mat.Matrix) {
+	n := float64(r.count + 1)
+	for index := range r.labels {
+		t := expected.At(index, 0)
+		residual := outputs.At(index, 0) - t
+		delta := t - r.meanT[index]
+		r.meanT[index] += delta / n
+		r.m2T[index] += delta * (t - r.meanT[index])
+		delta = residual - r.meanR[index]
+		r.meanR[index] += delta / n
+		r.m2R[index] += delta * (residual - r.meanR[index])
+		r.sumR2[index] += residual * residual
+		r.sumAbsR[index] += math.Abs(residual)
+		// Percentage errors are undefined for zero targets
+		if t != 0 {
+			r.sumPct[index] += math.Abs(residual / t)
+			r.countPct[index]++
+		}
+		r.residuals[index] = append(r.residuals[index], residual)
+	}
+	r.count++
+}
+
+type Histogram struct {
+	// Bins+1 edges, for Bins counts
+	Edges  []float64 `json:"edges"`
+	Counts []int     `json:"counts"`
+}
+
+type Quantile struct {
+	Q     float64 `json:"q"`
+	Value float64 `json:"value"`
+}
+
+type OutputMetrics struct {
+	Label             string     `json:"label"`
+	RMSE              float64    `json:"rmse"`
+	MAE               float64    `json:"mae"`
+	MAPE              float64    `json:"mape"`
+	R2                float64    `json:"r2"`
+	ExplainedVariance float64    `json:"explained_variance"`
+	MeanResidual      float64    `json:"mean_residual"`
+	Quantiles         []Quantile `json:"residual_quantiles"`
+	Histogram         Histogram  `json:"residual_histogram"`
+}
+
+// A snapshot of the accumulated metrics, ready to be written.
+// Overall RMSE, MAE and MAPE are computed over all the outputs,
+//   while overall R² and explained variance are the mean of the
+//   per-output ones.
+type RegressionReport struct {
+	Count             int             `json:"count"`
+	RMSE              float64         `json:"rmse"`
+	MAE               float64         `json:"mae"`
+	MAPE              float64         `json:"mape"`
+	R2                float64         `json:"r2"`
+	ExplainedVariance float64         `json:"explained_variance"`
+	Quantiles         []Quantile      `json:"residual_quantiles"`
+	Histogram         Histogram       `json:"residual_histogram"`
+	Outputs           []OutputMetrics `json:"outputs"`
+}
+
+// Builds the report, with residual histograms of the given bins
+func (r *Regression) Report(bins int) *RegressionReport {
+	if bins < 1 {
+		panic("bins must be >= 1")
+	}
+
+	report := &RegressionReport{Count: r.count, Outputs: make([]OutputMetrics, len(r.labels))}
+	if r.count == 0 {
+		return report
+	}
+
+	n := float64(r.count)
+	all := make([]float64, 0, r.count*len(r.labels))
+	sumR2, sumAbsR, sumPct, countPct := 0.0, 0.0, 0.0, 0
+	for index, label := range r.labels {
+		varianceT := r.m2T[index] / n
+		varianceR := r.m2R[index] / n
+		output := OutputMetrics{
+			Label:        label,
+			RMSE:         math.Sqrt(r.sumR2[index] / n),
+			MAE:          r.sumAbsR[index] / n,
+			MAPE:         mean(r.sumPct[index], r.countPct[index]) * 100,
+			R2:           explained(r.sumR2[index]/n, varianceT),
+			MeanResidual: r.meanR[index],
+		}
+		output.ExplainedVariance = explained(varianceR, varianceT)
+		sorted := append([]float64{}, r.residuals[index]...)
+		sort.Float64s(sorted)
+		output.Quantiles = quantiles(sorted)
+		output.Histogram = histogram(sorted, bins)
+		report.Outputs[index] = output
+
+		report.R2 += output.R2 / float64(len(r.labels))
+		report.ExplainedVariance += output.ExplainedVariance / float64(len(r.labels))
+		sumR2 += r.sumR2[index]
+		sumAbsR += r.sumAbsR[index]
+		sumPct += r.sumPct[index]
+		countPct += r.countPct[index]
+		all = append(all, sorted...)
+	}
+	total := n * float64(len(r.labels))
+	report.RMSE = math.Sqrt(sumR2 / total)
+	report.MAE = sumAbsR / total
+	report.MAPE = mean(sumPct, countPct) * 100
+	sort.Float64s(all)
+	report.Quantiles = quantiles(all)
+	report.Histogram = histogram(all, bins)
+	return report
+}
+
+func (report *RegressionReport) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+func (report *RegressionReport) WriteText(w io.Writer) error {
+	builder := &strings.Builder{}
+	fmt.Fprintf(builder, "Cases: %v\n", report.Count)
+	fmt.Fprintf(builder, "RMSE: %.6g\nMAE: %.6g\nMAPE: %.4g%%\nR²: %.6g\nExplained variance: %.6g\n\n",
+		report.RMSE, report.MAE, report.MAPE, report.R2, report.ExplainedVariance)
+
+	width := len("output")
+	for _, output := range report.Outputs {
+		if len(output.Label) > width {
+			width = len(output.Label)
+		}
+	}
+	fmt.Fprintf(builder, "%*s %12s %12s %12s %12s %12s\n", width, "output", "rmse", "mae", "mape%", "r2", "expl.var")
+	for _, output := range report.Outputs {
+		fmt.Fprintf(builder, "%*s %12.6g %12.6g %12.4g %12.6g %12.6g\n",
+			width, output.Label, output.RMSE, output.MAE, output.MAPE, output.R2, output.ExplainedVariance)
+	}
+
+	builder.WriteString("\nResidual quantiles:\n")
+	for _, quantile := range report.Quantiles {
+		fmt.Fprintf(builder, "  q%-5v %12.6g\n", quantile.Q, quantile.Value)
+	}
+
+	builder.WriteString("\nResidual histogram:\n")
+	maxCount := 0
+	for _, count := range report.Histogram.Counts {
+		if count > maxCount {
+			maxCount = count
+		}
+	}
+	for index, count := range report.Histogram.Counts {
+		bar := 0
+		if maxCount > 0 {
+			bar = count * 40 / maxCount
+		}
+		fmt.Fprintf(builder, "  [%12.6g, %12.6g) %8d %s\n",
+			report.Histogram.Edges[index], report.Histogram.Edges[index+1], count, strings.Repeat("#", bar))
+	}
+
+	_, err := io.WriteString(w, builder.String())
+	return err
+}
+
+func mean(sum float64, count int) float64 {
+	if count == 0 {
+		return 0
+	}
+	return sum / float64(count)
+}
+
+// 1 - unexplained / total, being 0 for a constant target
+func explained(unexplained, total float64) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return 1 - unexplained/total
+}
+
+// Linearly interpolated quantiles of sorted values
+func quantiles(sorted []float64) []Quantile {
+	result := make([]Quantile, len(residualQuantiles))
+	for index, q := range residualQuantiles {
+		result[index] = Quantile{Q: q}
+		if len(sorted) == 0 {
+			continue
+		}
+		position := q * float64(len(sorted)-1)
+		low := int(math.Floor(position))
+		high := int(math.Ceil(position))
+		fraction := position - float64(low)
+		result[index].Value = sorted[low]*(1-fraction) + sorted[high]*fraction
+	}
+	return result
+}
+
+// Equal width histogram of sorted values. The non-finite ones
+//   (as the residuals of a diverged network) are left out.
+func histogram(sorted []float64, bins int) Histogram {
+	result := Histogram{Edges: make([]float64, bins+1), Counts: make([]int, bins)}
+	finite := make([]float64, 0, len(sorted))
+	for _, value := range sorted {
+		if !math.IsNaN(value) && !math.IsInf(value, 0) {
+			finite = append(finite, value)
+		}
+	}
+	sorted = finite
+	if len(sorted) == 0 {
+		return result
+	}
+	min, max := sorted[0], sorted[len(sorted)-1]
+	width := (max - min) / float64(bins)
+	for index := range result.Edges {
+		result.Edges[index] = min + float64(index)*width
+	}
+	result.Edges[bins] = max
+	for _, value := range sorted {
+		bin := bins - 1
+		if width > 0 {
+			if bin = int((value - min) / width); bin >= bins {
+				bin = bins - 1
+			}
+		}
+		result.Counts[bin]++
+	}
+	return result
+}
@@ -0,0 +1,292 @@
+package monitoring
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Metrics written in the Prometheus text exposition format (version
+//   0.0.4), without any client library. Every metric is a family of
+//   series told apart by the values of its labels, given in the
+//   order of the label names.
+
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var namePattern = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+var labelPattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Latencies from 1ms to 10s
+var DefaultBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// The powers of 2, up to the first one reaching max
+func ExponentialBuckets(max float64) []float64 {
+	buckets := []float64{1}
+	for bound := 2.0; buckets[len(buckets) - 1] < max; bound *= 2 {
+		buckets = append(buckets, bound)
+	}
+	return buckets
+}
+
+
+type family struct {
+	name       string
+	help       string
+	kind       string
+	labelNames []string
+	// Histograms only: the upper bounds of the buckets
+	buckets []float64
+	mutex   sync.Mutex
+	// By the joined label values
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	// The value of counters and gauges, or the sum of histograms
+	value float64
+	// Histograms only: the count per bucket (not cumulative), and
+	//   of all the observations
+	counts []uint64
+	count  uint64
+}
+
+func (f *family) get(labelValues []string) *series {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("%v takes %v label values, got %v", f.name, len(f.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string{}, labelValues...)}
+		f.series[key] = s
+	}
+	return s
+}
+
+
+// A value that only goes up
+type Counter struct {
+	family *family
+}
+
+func (c *Counter) Add(value float64, labelValues ...string) {
+	if value < 0 {
+		panic("counters cannot decrease")
+	}
+	c.family.mutex.Lock()
+	defer c.family.mutex.Unlock()
+	c.family.get(labelValues).value += value
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+
+// A value that goes up and down
+type Gauge struct {
+	family *family
+}
+
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.family.mutex.Lock()
+	defer g.family.mutex.Unlock()
+	g.family.get(labelValues).value = value
+}
+
+func (g *Gauge) Add(value float64, labelValues ...string) {
+	g.family.mutex.Lock()
+	defer g.family.mutex.Unlock()
+	g.family.get(labelValues).value += value
+}
+
+
+// Counts the observations into buckets by their upper bounds
+type Histogram struct {
+	family *family
+}
+
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	// The first bucket holding it, or the +Inf one
+	index := sort.SearchFloat64s(h.family.buckets, value)
+	h.family.mutex.Lock()
+	defer h.family.mutex.Unlock()
+	s := h.family.get(labelValues)
+	if s.counts == nil {
+		s.counts = make([]uint64, len(h.family.buckets) + 1)
+	}
+	s.counts[index]++
+	s.count++
+	s.value += value
+}
+
+
+// A set of metrics, written together
+type Registry struct {
+	mutex    sync.Mutex
+	families []*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (registry *Registry) add(name, help, kind string, labelNames []string, buckets []float64) *family {
+	if !namePattern.MatchString(name) {
+		panic(fmt.Sprintf("invalid metric name: %q", name))
+	}
+	for _, label := range labelNames {
+		if !labelPattern.MatchString(label) || label == "le" || strings.HasPrefix(label, "__") {
+			panic(fmt.Sprintf("invalid label name: %q", label))
+		}
+	}
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	for _, f := range registry.families {
+		if f.name != name {
+			continue
+		}
+		if f.kind != kind || !equalStrings(f.labelNames, labelNames) || !equalFloats(f.buckets, buckets) {
+			panic(fmt.Sprintf("metric %v already registered differently", name))
+		}
+		return f
+	}
+	f := &family{name: name, help: help, kind: kind, labelNames: labelNames, buckets: buckets, series: map[string]*series{}}
+	registry.families = append(registry.families, f)
+	return f
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for index := range a {
+		if a[index] != b[index] {
+			return false
+		}
+	}
+	return true
+}
+
+func equalFloats(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for index := range a {
+		if a[index] != b[index] {
+			return false
+		}
+	}
+	return true
+}
+
+// Registering a metric again (e.g. from another training run, or
+//   server, sharing the registry) gives the existing one, as long
+//   as it has the same kind, labels and buckets
+func (registry *Registry) NewCounter(name, help string, labelNames ...string) *Counter {
+	return &Counter{family: registry.add(name, help, "counter", labelNames, nil)}
+}
+
+func (registry *Registry) NewGauge(name, help string, labelNames ...string) *Gauge {
+	return &Gauge{family: registry.add(name, help, "gauge", labelNames, nil)}
+}
+
+// The buckets are the upper bounds, increasing (the +Inf one is
+//   implicit)
+func (registry *Registry) NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	if len(buckets) == 0 || !sort.Float64sAreSorted(buckets) || math.IsInf(buckets[len(buckets) - 1], 1) {
+		panic("the buckets must be increasing finite bounds")
+	}
+	return &Histogram{family: registry.add(name, help, "histogram", labelNames, append([]float64{}, buckets...))}
+}
+
+
+func escape(value string, quoted bool) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, "\n", `\n`)
+	if quoted {
+		value = strings.ReplaceAll(value, `"`, `\"`)
+	}
+	return value
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// The labels as {name="value",...}, with an extra one if not empty
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	var pairs []string
+	for index, name := range names {
+		pairs = append(pairs, fmt.Sprintf(`%v="%v"`, name, escape(values[index], true)))
+	}
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf(`%v="%v"`, extraName, extraValue))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Writes all the metrics, each family sorted by its label values
+func (registry *Registry) Write(w io.Writer) error {
+	registry.mutex.Lock()
+	families := append([]*family{}, registry.families...)
+	registry.mutex.Unlock()
+
+	writer := bufio.NewWriter(w)
+	for _, f := range families {
+		fmt.Fprintf(writer, "# HELP %v %v\n# TYPE %v %v\n", f.name, escape(f.help, false), f.name, f.kind)
+		f.mutex.Lock()
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s := f.series[key]
+			if f.kind != "histogram" {
+				fmt.Fprintf(writer, "%v%v %v\n", f.name, formatLabels(f.labelNames, s.labelValues, "", ""), formatValue(s.value))
+				continue
+			}
+			cumulative := uint64(0)
+			for index, count := range s.counts {
+				cumulative += count
+				bound := "+Inf"
+				if index < len(f.buckets) {
+					bound = formatValue(f.buckets[index])
+				}
+				fmt.Fprintf(writer, "%v_bucket%v %v\n", f.name, formatLabels(f.labelNames, s.labelValues, "le", bound), cumulative)
+			}
+			labels := formatLabels(f.labelNames, s.labelValues, "", "")
+			fmt.Fprintf(writer, "%v_sum%v %v\n%v_count%v %v\n", f.name, labels, formatValue(s.value), f.name, labels, s.count)
+		}
+		f.mutex.Unlock()
+	}
+	return writer.Flush()
+}
+
+// Serves the metrics, as scraped by Prometheus
+func (registry *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		registry.Write(w)
+	})
+}
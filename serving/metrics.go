@@ -0,0 +1,86 @@
+package serving
+
+import (
+	"../monitoring"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The metrics of the serving, exposed at /metrics
+type servingMetrics struct {
+	registry  *monitoring.Registry
+	requests  *monitoring.Counter
+	latency   *monitoring.Histogram
+	batchSize *monitoring.Histogram
+	classes   *monitoring.Counter
+	version   *monitoring.Gauge
+	reloads   *monitoring.Counter
+}
+
+func newServingMetrics(registry *monitoring.Registry, maxBatchSize int) *servingMetrics {
+	return &servingMetrics{
+		registry: registry,
+		requests: registry.NewCounter("ffnn_http_requests_total", "HTTP requests, by endpoint and status code.", "endpoint", "code"),
+		latency: registry.NewHistogram(
+			"ffnn_http_request_duration_seconds", "Time to answer the HTTP requests, by endpoint.", monitoring.DefaultBuckets, "endpoint",
+		),
+		batchSize: registry.NewHistogram(
+			"ffnn_predict_batch_size", "Inputs per prediction request.", monitoring.ExponentialBuckets(float64(maxBatchSize)),
+		),
+		classes: registry.NewCounter("ffnn_predictions_total", "Predictions, by class (index of the highest output).", "class"),
+		version: registry.NewGauge("ffnn_model_version", "Version of the served model (1 for the first one)."),
+		reloads: registry.NewCounter("ffnn_model_reloads_total", "Reload attempts, by result: swapped, unchanged or failed.", "result"),
+	}
+}
+
+func (metrics *servingMetrics) reloaded(swapped bool, err error) {
+	switch {
+	case err != nil:
+		metrics.reloads.Inc("failed")
+	case swapped:
+		metrics.reloads.Inc("swapped")
+	default:
+		metrics.reloads.Inc("unchanged")
+	}
+}
+
+
+// Records the status written through it
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (recorder *statusRecorder) WriteHeader(status int) {
+	if recorder.status == 0 {
+		recorder.status = status
+	}
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func (recorder *statusRecorder) Write(data []byte) (int, error) {
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+	return recorder.ResponseWriter.Write(data)
+}
+
+// Counts and times the requests. The unknown paths are labeled
+//   "other", so they cannot create series at will.
+func (metrics *servingMetrics) instrument(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		mux.ServeHTTP(recorder, r)
+		endpoint := "other"
+		if _, pattern := mux.Handler(r); pattern == r.URL.Path {
+			endpoint = pattern
+		}
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		metrics.requests.Inc(endpoint, strconv.Itoa(recorder.status))
+		metrics.latency.Observe(time.Since(started).Seconds(), endpoint)
+	})
+}
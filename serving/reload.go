@@ -0,0 +1,107 @@
+package serving
+
+import (
+	"../ffnn"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Loads the network to serve, e.g. with ffnn.LoadWith
+type Loader func() (*ffnn.FFNetwork, error)
+
+// Loads the network in the caller's goroutine, while the served one
+//   keeps answering, and swaps it in if valid (see Swap). Networks
+//   with the checksum of the served one are not swapped. Tells
+//   whether it was swapped. On failure the served network stays,
+//   and the error is reported by /version too.
+func (handler *Handler) Reload(load Loader) (bool, error) {
+	handler.reloading.Lock()
+	defer handler.reloading.Unlock()
+	network, err := safeLoad(load)
+
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+	swapped := false
+	if err == nil {
+		checksum := network.Checksum()
+		if checksum == "" || checksum != handler.model().info.Checksum {
+			_, err = handler.swap(network)
+			swapped = err == nil
+		}
+	}
+	now := time.Now().UTC()
+	handler.lastReload, handler.lastReloadError = &now, ""
+	if err != nil {
+		handler.lastReloadError = err.Error()
+	}
+	handler.metrics.reloaded(swapped, err)
+	return swapped, err
+}
+
+
+// Loads the network, turning panics (e.g. on corrupt files) into
+//   errors, so they don't take the server down
+func safeLoad(load Loader) (network *ffnn.FFNetwork, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			network, err = nil, errors.New(fmt.Sprintf("loading failed: %v", r))
+		}
+	}()
+	return load()
+}
+
+
+// What tells a file changed
+type fileState struct {
+	modified int64
+	size     int64
+}
+
+func stat(filename string) (fileState, error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return fileState{}, err
+	}
+	return fileState{modified: info.ModTime().UnixNano(), size: info.Size()}, nil
+}
+
+// Reloads the network whenever the file changes, polling it every
+//   interval until the context is done. A change is reloaded once
+//   the file stays the same for an interval, so files still being
+//   written are not read. Each reload is reported (when report is
+//   not nil) as Reload returns it.
+func (handler *Handler) WatchFile(
+	ctx context.Context, filename string, interval time.Duration, load Loader, report func(swapped bool, err error),
+) {
+	// The file as it was when the served network was loaded (as
+	//   far as it can be told)
+	loaded, _ := stat(filename)
+	var pending *fileState
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		// A missing file is usually being replaced
+		current, err := stat(filename)
+		if err != nil || current == loaded {
+			pending = nil
+			continue
+		}
+		if pending == nil || *pending != current {
+			pending = &current
+			continue
+		}
+		loaded, pending = current, nil
+		swapped, err := handler.Reload(load)
+		if report != nil {
+			report(swapped, err)
+		}
+	}
+}
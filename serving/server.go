@@ -0,0 +1,427 @@
+package serving
+
+import (
+	"../ffnn"
+	"../metrics"
+	"../monitoring"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gonum.org/v1/gonum/mat"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The limits used when the options leave them as 0
+const (
+	DefaultMaxBodyBytes = 4 << 20
+	DefaultMaxBatchSize = 1024
+)
+
+type Options struct {
+	// The largest request body accepted, in bytes
+	MaxBodyBytes int64
+	// The most vectors accepted in a batch
+	MaxBatchSize int
+	// Where to register the metrics of the serving (a new registry
+	//   when nil)
+	Metrics *monitoring.Registry
+}
+
+
+// A prediction request, holding either a single input vector or a
+//   batch of them
+type PredictRequest struct {
+	Input  []float64   `json:"input,omitempty"`
+	Inputs [][]float64 `json:"inputs,omitempty"`
+}
+
+// The outputs of a single vector, and the index of the highest one
+type Prediction struct {
+	Output []float64 `json:"output"`
+	Class  int       `json:"class"`
+}
+
+// The response to a batch request, in the order of the inputs
+type BatchPrediction struct {
+	Predictions []Prediction `json:"predictions"`
+}
+
+type LayerInfo struct {
+	InputSize  int    `json:"input_size"`
+	OutputSize int    `json:"output_size"`
+	Activator  string `json:"activator"`
+}
+
+// The architecture of the served network, and its provenance
+type ModelInfo struct {
+	InputSize     int            `json:"input_size"`
+	OutputSize    int            `json:"output_size"`
+	Preprocessing []string       `json:"preprocessing,omitempty"`
+	Layers        []LayerInfo    `json:"layers"`
+	TargetScaling []string       `json:"target_scaling,omitempty"`
+	ErrorMetric   string         `json:"error_metric"`
+	Checksum      string         `json:"checksum,omitempty"`
+	Metadata      *ffnn.Metadata `json:"metadata,omitempty"`
+}
+
+func describe(network *ffnn.FFNetwork) *ModelInfo {
+	info := &ModelInfo{
+		InputSize:   network.InputSize(),
+		OutputSize:  network.Layer(network.LayersCount() - 1).OutputSize(),
+		ErrorMetric: network.ErrorMetric().Name(),
+		Checksum:    network.Checksum(),
+		Metadata:    network.Metadata(),
+	}
+	if preprocessing := network.Preprocessing(); preprocessing != nil {
+		for _, step := range preprocessing.Steps() {
+			info.Preprocessing = append(info.Preprocessing, step.Name())
+		}
+	}
+	for index := 0; index < network.LayersCount(); index++ {
+		layer := network.Layer(index)
+		info.Layers = append(info.Layers, LayerInfo{
+			InputSize: layer.InputSize(), OutputSize: layer.OutputSize(), Activator: layer.Activator().Name(),
+		})
+	}
+	if scaling := network.TargetScaling(); scaling != nil {
+		for _, scaler := range scaling.Scalers() {
+			info.TargetScaling = append(info.TargetScaling, scaler.Name())
+		}
+	}
+	return info
+}
+
+
+// A network being served, numbered by its version (1 for the
+//   first one, increasing with each swap)
+type model struct {
+	network *ffnn.FFNetwork
+	info    *ModelInfo
+	version int
+	loaded  time.Time
+}
+
+// The served model, and how its reloads went
+type VersionInfo struct {
+	Version  int       `json:"version"`
+	Checksum string    `json:"checksum,omitempty"`
+	Loaded   time.Time `json:"loaded"`
+	// The last reload attempt, and its error if it failed (the
+	//   former model being served meanwhile)
+	LastReload      *time.Time `json:"last_reload,omitempty"`
+	LastReloadError string     `json:"last_reload_error,omitempty"`
+}
+
+
+// Serves the predictions of a network over HTTP, as JSON:
+//
+//   POST /predict   {"input": [...]} or {"inputs": [[...], ...]}
+//   GET  /model     the architecture and metadata of the network
+//   GET  /version   the version and checksum of the network
+//   GET  /healthz   whether the server is alive
+//   GET  /readyz    whether the server takes requests, with the
+//                   served version and the last reload error
+//   GET  /metrics   the metrics of the serving, for Prometheus
+//
+// The predictions are safe for concurrent use (see
+//   FFNetwork.Predict), so the network must not be trained while
+//   served. It can be replaced meanwhile, though (see Swap and
+//   Reload): each request is answered by a single model, whose
+//   version is in the X-Model-Version header.
+type Handler struct {
+	// The current *model
+	current atomic.Value
+	options Options
+	mux     *http.ServeMux
+	metrics *servingMetrics
+	handler http.Handler
+	// Serializes the swaps, and guards the reload status
+	mutex           sync.Mutex
+	lastReload      *time.Time
+	lastReloadError string
+	draining        bool
+	// Serializes the reloads (which load without the mutex)
+	reloading sync.Mutex
+}
+
+func NewHandler(network *ffnn.FFNetwork, options Options) *Handler {
+	if network == nil {
+		panic("network is nil")
+	}
+	if options.MaxBodyBytes == 0 {
+		options.MaxBodyBytes = DefaultMaxBodyBytes
+	}
+	if options.MaxBatchSize == 0 {
+		options.MaxBatchSize = DefaultMaxBatchSize
+	}
+	if options.MaxBodyBytes < 0 || options.MaxBatchSize < 0 {
+		panic("the limits must be >= 0")
+	}
+	if options.Metrics == nil {
+		options.Metrics = monitoring.NewRegistry()
+	}
+	handler := &Handler{options: options, mux: http.NewServeMux()}
+	handler.metrics = newServingMetrics(options.Metrics, options.MaxBatchSize)
+	handler.handler = handler.metrics.instrument(handler.mux)
+	handler.current.Store(&model{network: network, info: describe(network), version: 1, loaded: time.Now().UTC()})
+	handler.metrics.version.Set(1)
+	handler.mux.HandleFunc("/predict", handler.servePredict)
+	handler.mux.HandleFunc("/model", handler.serveModel)
+	handler.mux.HandleFunc("/version", handler.serveVersion)
+	handler.mux.HandleFunc("/healthz", handler.serveHealth)
+	handler.mux.HandleFunc("/readyz", handler.serveReady)
+	handler.mux.Handle("/metrics", options.Metrics.Handler())
+	return handler
+}
+
+func (handler *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	handler.handler.ServeHTTP(w, r)
+}
+
+func (handler *Handler) model() *model {
+	return handler.current.Load().(*model)
+}
+
+// The network being served
+func (handler *Handler) Network() *ffnn.FFNetwork {
+	return handler.model().network
+}
+
+func (handler *Handler) Version() VersionInfo {
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+	current := handler.model()
+	return VersionInfo{
+		Version: current.version, Checksum: current.info.Checksum, Loaded: current.loaded,
+		LastReload: handler.lastReload, LastReloadError: handler.lastReloadError,
+	}
+}
+
+// Reports not ready from now on, so the load balancers stop
+//   sending requests before the server shuts down
+func (handler *Handler) Drain() {
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+	handler.draining = true
+}
+
+// Checks that the network can replace the served one: it must
+//   take and give as many values, and predict finite outputs
+func (handler *Handler) validate(network *ffnn.FFNetwork) (err error) {
+	if network == nil {
+		return errors.New("network is nil")
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.New(fmt.Sprintf("the network fails to predict: %v", r))
+		}
+	}()
+	served, info := handler.model().info, describe(network)
+	if info.InputSize != served.InputSize || info.OutputSize != served.OutputSize {
+		return errors.New(fmt.Sprintf(
+			"the network maps %v inputs to %v outputs, but the served one %v to %v",
+			info.InputSize, info.OutputSize, served.InputSize, served.OutputSize,
+		))
+	}
+	output := network.Predict(mat.NewDense(info.InputSize, 1, nil))
+	for _, value := range output.RawMatrix().Data {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return errors.New("the network predicts non-finite outputs")
+		}
+	}
+	return nil
+}
+
+// Validates the network and, if valid, serves it from now on
+//   (the requests in progress finish with the former one). Returns
+//   its version.
+func (handler *Handler) Swap(network *ffnn.FFNetwork) (int, error) {
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+	return handler.swap(network)
+}
+
+// Swaps the network in. The mutex must be held.
+func (handler *Handler) swap(network *ffnn.FFNetwork) (int, error) {
+	if err := handler.validate(network); err != nil {
+		return 0, err
+	}
+	version := handler.model().version + 1
+	handler.current.Store(&model{network: network, info: describe(network), version: version, loaded: time.Now().UTC()})
+	handler.metrics.version.Set(float64(version))
+	return version, nil
+}
+
+
+// An error to answer with its status
+type requestError struct {
+	status  int
+	message string
+}
+
+func (err *requestError) Error() string {
+	return err.message
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &requestError{http.StatusBadRequest, fmt.Sprintf(format, args...)}
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	// Encoding first, since it fails for non-finite outputs
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		status = http.StatusInternalServerError
+		encoded, _ = json.Marshal(map[string]string{"error": err.Error()})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(encoded, '\n'))
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var requestErr *requestError
+	if errors.As(err, &requestErr) {
+		status = requestErr.status
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// Answers 405 (and tells so) when the method is not the allowed one
+func allow(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method || (method == http.MethodGet && r.Method == http.MethodHead) {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeError(w, &requestError{http.StatusMethodNotAllowed, fmt.Sprintf("method %v not allowed", r.Method)})
+	return false
+}
+
+
+// Reads and validates the request: its size, and the count and
+//   shape of its vectors
+func (handler *Handler) readRequest(w http.ResponseWriter, r *http.Request, inputSize int) (*PredictRequest, error) {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, handler.options.MaxBodyBytes))
+	decoder.DisallowUnknownFields()
+	var request PredictRequest
+	if err := decoder.Decode(&request); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, &requestError{
+				http.StatusRequestEntityTooLarge, fmt.Sprintf("the request exceeds %v bytes", handler.options.MaxBodyBytes),
+			}
+		}
+		return nil, badRequest("invalid JSON: %v", err)
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, badRequest("invalid JSON: unexpected data after the request")
+	}
+
+	switch {
+	case request.Input != nil && request.Inputs != nil:
+		return nil, badRequest("either \"input\" or \"inputs\" is expected, not both")
+	case request.Input != nil:
+		if len(request.Input) != inputSize {
+			return nil, badRequest("the input has %v values, expected %v", len(request.Input), inputSize)
+		}
+	case request.Inputs != nil:
+		if len(request.Inputs) == 0 {
+			return nil, badRequest("the batch is empty")
+		}
+		if len(request.Inputs) > handler.options.MaxBatchSize {
+			return nil, &requestError{
+				http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("the batch has %v inputs, at most %v are accepted", len(request.Inputs), handler.options.MaxBatchSize),
+			}
+		}
+		for index, input := range request.Inputs {
+			if len(input) != inputSize {
+				return nil, badRequest("input %v has %v values, expected %v", index, len(input), inputSize)
+			}
+		}
+	default:
+		return nil, badRequest("an \"input\" vector or a batch of \"inputs\" is expected")
+	}
+	return &request, nil
+}
+
+func predict(network *ffnn.FFNetwork, input []float64) Prediction {
+	output := network.Predict(mat.NewDense(len(input), 1, input))
+	return Prediction{Output: mat.Col(nil, 0, output), Class: metrics.Argmax(output)}
+}
+
+func (handler *Handler) servePredict(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodPost) {
+		return
+	}
+	// The whole request is answered by this model, even if swapped
+	current := handler.model()
+	request, err := handler.readRequest(w, r, current.info.InputSize)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("X-Model-Version", strconv.Itoa(current.version))
+	if request.Input != nil {
+		prediction := predict(current.network, request.Input)
+		handler.metrics.batchSize.Observe(1)
+		handler.metrics.classes.Inc(strconv.Itoa(prediction.Class))
+		writeJSON(w, http.StatusOK, prediction)
+		return
+	}
+	batch := BatchPrediction{Predictions: make([]Prediction, len(request.Inputs))}
+	for index, input := range request.Inputs {
+		batch.Predictions[index] = predict(current.network, input)
+		handler.metrics.classes.Inc(strconv.Itoa(batch.Predictions[index].Class))
+	}
+	handler.metrics.batchSize.Observe(float64(len(request.Inputs)))
+	writeJSON(w, http.StatusOK, batch)
+}
+
+func (handler *Handler) serveModel(w http.ResponseWriter, r *http.Request) {
+	if allow(w, r, http.MethodGet) {
+		writeJSON(w, http.StatusOK, handler.model().info)
+	}
+}
+
+func (handler *Handler) serveVersion(w http.ResponseWriter, r *http.Request) {
+	if allow(w, r, http.MethodGet) {
+		writeJSON(w, http.StatusOK, handler.Version())
+	}
+}
+
+func (handler *Handler) serveHealth(w http.ResponseWriter, r *http.Request) {
+	if allow(w, r, http.MethodGet) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
+
+type readiness struct {
+	// "ready" or "draining"
+	Status          string `json:"status"`
+	Version         int    `json:"version"`
+	LastReloadError string `json:"last_reload_error,omitempty"`
+}
+
+// Ready unless draining, since a model is always served: a failed
+//   reload leaves the former one, so it is only reported
+func (handler *Handler) serveReady(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	handler.mutex.Lock()
+	response := readiness{Status: "ready", Version: handler.model().version, LastReloadError: handler.lastReloadError}
+	code := http.StatusOK
+	if handler.draining {
+		response.Status, code = "draining", http.StatusServiceUnavailable
+	}
+	handler.mutex.Unlock()
+	writeJSON(w, code, response)
+}
@@ -0,0 +1,207 @@
+package tensorboard
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// The event files are sequences of records, each one being (all
+//   little-endian):
+//
+//   length      uint64, of the data
+//   lengthCRC   uint32, masked CRC32C of the length bytes
+//   data        a serialized tensorflow.Event protocol buffer
+//   dataCRC     uint32, masked CRC32C of the data
+//
+// The messages written (with their field numbers) are:
+//
+//   Event:          wall_time (1, double), step (2, int64),
+//                   file_version (3, string), summary (5)
+//   Summary:        value (1, repeated)
+//   Summary.Value:  tag (1, string), simple_value (2, float),
+//                   histo (5)
+//   HistogramProto: min (1), max (2), num (3), sum (4),
+//                   sum_squares (5), bucket_limit (6, packed),
+//                   bucket (7, packed), all doubles
+
+const fileVersion = "brain.Event:2"
+
+// How many buckets the histograms have
+const HistogramBuckets = 30
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+func maskedCRC(data []byte) uint32 {
+	crc := crc32.Checksum(data, castagnoli)
+	return ((crc >> 15) | (crc << 17)) + 0xa282ead8
+}
+
+// Writes an event file which TensorBoard reads (e.g. with
+//   `tensorboard --logdir <directory>`). It is safe for concurrent
+//   use.
+type Writer struct {
+	mutex    sync.Mutex
+	file     *os.File
+	buffered *bufio.Writer
+	filename string
+}
+
+// Creates a new event file in the directory (creating it too, if
+//   needed), named as TensorBoard expects
+func NewWriter(directory string) (*Writer, error) {
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return nil, err
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	now := time.Now()
+	filename := filepath.Join(directory, fmt.Sprintf("events.out.tfevents.%v.%v", now.Unix(), hostname))
+	file, err := os.OpenFile(filename, os.O_WRONLY | os.O_CREATE | os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	writer := &Writer{file: file, buffered: bufio.NewWriter(file), filename: filename}
+
+	event := &message{}
+	event.writeDouble(1, wallTime(now))
+	event.writeString(3, fileVersion)
+	if err := writer.write(event); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return writer, writer.Flush()
+}
+
+func (writer *Writer) Filename() string {
+	return writer.filename
+}
+
+func wallTime(t time.Time) float64 {
+	return float64(t.UnixNano()) / 1e9
+}
+
+// Writes a record, framed, with the event
+func (writer *Writer) write(event *message) error {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	if writer.file == nil {
+		return errors.New("the event writer is closed")
+	}
+	var header [12]byte
+	binary.LittleEndian.PutUint64(header[:8], uint64(len(event.data)))
+	binary.LittleEndian.PutUint32(header[8:], maskedCRC(header[:8]))
+	var footer [4]byte
+	binary.LittleEndian.PutUint32(footer[:], maskedCRC(event.data))
+	for _, data := range [][]byte{header[:], event.data, footer[:]} {
+		if _, err := writer.buffered.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Writes an event with a summary holding a single value
+func (writer *Writer) summary(step int, value *message) error {
+	summary := &message{}
+	summary.writeMessage(1, value)
+	event := &message{}
+	event.writeDouble(1, wallTime(time.Now()))
+	event.writeInt64(2, int64(step))
+	event.writeMessage(5, summary)
+	return writer.write(event)
+}
+
+func (writer *Writer) Scalar(tag string, step int, value float64) error {
+	summaryValue := &message{}
+	summaryValue.writeString(1, tag)
+	summaryValue.writeFloat(2, float32(value))
+	return writer.summary(step, summaryValue)
+}
+
+// Writes the histogram of the values, with HistogramBuckets equal
+//   buckets between their minimum and maximum. Non-finite values
+//   (as in diverged trainings) are left out, since they have no
+//   bucket.
+func (writer *Writer) Histogram(tag string, step int, values []float64) error {
+	finite := make([]float64, 0, len(values))
+	for _, value := range values {
+		if !math.IsNaN(value) && !math.IsInf(value, 0) {
+			finite = append(finite, value)
+		}
+	}
+	values = finite
+	if len(values) == 0 {
+		return nil
+	}
+	min, max, sum, squares := math.Inf(1), math.Inf(-1), 0.0, 0.0
+	for _, value := range values {
+		min, max = math.Min(min, value), math.Max(max, value)
+		sum += value
+		squares += value * value
+	}
+
+	limits := make([]float64, HistogramBuckets)
+	counts := make([]float64, HistogramBuckets)
+	width := (max - min) / HistogramBuckets
+	for index := range limits {
+		limits[index] = min + width * float64(index + 1)
+	}
+	limits[HistogramBuckets - 1] = max
+	for _, value := range values {
+		index := HistogramBuckets - 1
+		if width > 0 {
+			index = int((value - min) / width)
+			if index >= HistogramBuckets {
+				index = HistogramBuckets - 1
+			}
+		}
+		counts[index]++
+	}
+
+	histogram := &message{}
+	histogram.writeDouble(1, min)
+	histogram.writeDouble(2, max)
+	histogram.writeDouble(3, float64(len(values)))
+	histogram.writeDouble(4, sum)
+	histogram.writeDouble(5, squares)
+	histogram.writePackedDoubles(6, limits)
+	histogram.writePackedDoubles(7, counts)
+	summaryValue := &message{}
+	summaryValue.writeString(1, tag)
+	summaryValue.writeMessage(5, histogram)
+	return writer.summary(step, summaryValue)
+}
+
+// Makes the events written so far visible to TensorBoard
+func (writer *Writer) Flush() error {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	if writer.file == nil {
+		return nil
+	}
+	return writer.buffered.Flush()
+}
+
+func (writer *Writer) Close() error {
+	err := writer.Flush()
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	if writer.file == nil {
+		return err
+	}
+	if closeErr := writer.file.Close(); err == nil {
+		err = closeErr
+	}
+	writer.file = nil
+	return err
+}
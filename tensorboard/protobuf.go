@@ -0,0 +1,73 @@
+package tensorboard
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Protocol buffers wire types
+const (
+	varintType  = 0
+	fixed64Type = 1
+	bytesType   = 2
+	fixed32Type = 5
+)
+
+// A minimal protocol buffers encoder, enough for the few messages
+//   of the event files (so no protobuf dependency is needed)
+type message struct {
+	data []byte
+}
+
+func (m *message) writeVarint(value uint64) {
+	for value >= 0x80 {
+		m.data = append(m.data, byte(value) | 0x80)
+		value >>= 7
+	}
+	m.data = append(m.data, byte(value))
+}
+
+func (m *message) writeKey(field int, wireType int) {
+	m.writeVarint(uint64(field << 3 | wireType))
+}
+
+func (m *message) writeInt64(field int, value int64) {
+	m.writeKey(field, varintType)
+	m.writeVarint(uint64(value))
+}
+
+func (m *message) writeDouble(field int, value float64) {
+	m.writeKey(field, fixed64Type)
+	var buffer [8]byte
+	binary.LittleEndian.PutUint64(buffer[:], math.Float64bits(value))
+	m.data = append(m.data, buffer[:]...)
+}
+
+func (m *message) writeFloat(field int, value float32) {
+	m.writeKey(field, fixed32Type)
+	var buffer [4]byte
+	binary.LittleEndian.PutUint32(buffer[:], math.Float32bits(value))
+	m.data = append(m.data, buffer[:]...)
+}
+
+func (m *message) writeBytes(field int, value []byte) {
+	m.writeKey(field, bytesType)
+	m.writeVarint(uint64(len(value)))
+	m.data = append(m.data, value...)
+}
+
+func (m *message) writeString(field int, value string) {
+	m.writeBytes(field, []byte(value))
+}
+
+func (m *message) writeMessage(field int, value *message) {
+	m.writeBytes(field, value.data)
+}
+
+func (m *message) writePackedDoubles(field int, values []float64) {
+	packed := make([]byte, 8 * len(values))
+	for index, value := range values {
+		binary.LittleEndian.PutUint64(packed[8 * index:], math.Float64bits(value))
+	}
+	m.writeBytes(field, packed)
+}
@@ -2,10 +2,19 @@ package matrices
 
 import (
 	"gonum.org/v1/gonum/mat"
-	"gonum.org/v1/gonum/stat/distuv"
 	"math"
+	"math/rand"
+	"time"
 )
 
+// The generator for all the noise
+var random = rand.New(rand.NewSource(time.Now().UTC().UnixNano()))
+
+// Seeds the noise generator, so the noise can be reproduced
+func Seed(seed int64) {
+	random.Seed(seed)
+}
+
 func Fill(rows, columns int, value float64) *mat.Dense {
 	elements := make([]float64, rows * columns)
 	for index := range elements {
@@ -25,9 +34,8 @@ func FillColumn(rows int, value float64) *mat.Dense {
 func Noise(rows, columns int, cap float64) *mat.Dense {
 	elements := make([]float64, rows * columns)
 	cap = math.Abs(cap)
-	random := distuv.Uniform{Min: -cap, Max: cap}
 	for index := range elements {
-		elements[index] = random.Rand()
+		elements[index] = (random.Float64() * 2 - 1) * cap
 	}
 	return mat.NewDense(rows, columns, elements)
 }
@@ -39,3 +47,12 @@ func NoiseRow(columns int, cap float64) *mat.Dense {
 func NoiseColumn(rows int, cap float64) *mat.Dense {
 	return Noise(rows, 1, cap)
 }
+
+func NormalNoise(rows, columns int, stdDev float64) *mat.Dense {
+	elements := make([]float64, rows * columns)
+	stdDev = math.Abs(stdDev)
+	for index := range elements {
+		elements[index] = random.NormFloat64() * stdDev
+	}
+	return mat.NewDense(rows, columns, elements)
+}
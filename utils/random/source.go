@@ -0,0 +1,52 @@
+package random
+
+// A small (xorshift64*) generator implementing rand.Source64,
+//   whose state can be saved and restored (unlike the ones in
+//   math/rand), so a training can be resumed with the very same
+//   random draws. Wrap it with rand.New for the usual methods, but
+//   avoid Rand.Read, which buffers outside of the source.
+type Source struct {
+	state uint64
+}
+
+func NewSource(seed int64) *Source {
+	source := &Source{}
+	source.Seed(seed)
+	return source
+}
+
+// Spreads the seed (with SplitMix64), since the state must not be
+//   zero and close seeds should not give close sequences
+func (source *Source) Seed(seed int64) {
+	z := uint64(seed) + 0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	z = z ^ (z >> 31)
+	if z == 0 {
+		z = 0x9e3779b97f4a7c15
+	}
+	source.state = z
+}
+
+func (source *Source) Uint64() uint64 {
+	source.state ^= source.state >> 12
+	source.state ^= source.state << 25
+	source.state ^= source.state >> 27
+	return source.state * 0x2545f4914f6cdd1d
+}
+
+func (source *Source) Int63() int64 {
+	return int64(source.Uint64() >> 1)
+}
+
+func (source *Source) State() uint64 {
+	return source.state
+}
+
+// Restores a state given by State. Zero states are ignored, since
+//   the generator would get stuck on them.
+func (source *Source) SetState(state uint64) {
+	if state != 0 {
+		source.state = state
+	}
+}